@@ -0,0 +1,155 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"filippo.io/age"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const AgeKeyProviderName = "age"
+
+// AgeIdentityFileEnvVar can be used to point at the age identity file(s) used for decrypting the Data Key.
+// Multiple files can be listed, separated by the OS path list separator (":" on Unix).
+const AgeIdentityFileEnvVar = "CONFIGSTORE_AGE_IDENTITY_FILE"
+
+// WithAgeIdentityFiles sets the age identity files used for decrypting the Data Key of age-backed Configstores,
+// instead of the ones set via CONFIGSTORE_AGE_IDENTITY_FILE (or the default location)
+func WithAgeIdentityFiles(paths []string) ClientOption {
+	return func(c *ConfigstoreClient) {
+		c.providerConfig.AgeIdentityFiles = paths
+	}
+}
+
+// defaultAgeIdentityFiles returns the identity files listed in CONFIGSTORE_AGE_IDENTITY_FILE, falling back
+// to ~/.config/configstore/age/keys.txt if that's not set
+func defaultAgeIdentityFiles() []string {
+	if paths := os.Getenv(AgeIdentityFileEnvVar); paths != "" {
+		return filepath.SplitList(paths)
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil
+	}
+
+	return []string{filepath.Join(home, ".config", "configstore", "age", "keys.txt")}
+}
+
+func loadAgeIdentities(paths []string) ([]age.Identity, error) {
+	identities := make([]age.Identity, 0)
+
+	for _, path := range paths {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("%w; Failed to read age identity file: %s", err, path)
+		}
+
+		ids, err := age.ParseIdentities(bytes.NewReader(b))
+		if err != nil {
+			return nil, fmt.Errorf("%w; Failed to parse age identity file: %s", err, path)
+		}
+
+		identities = append(identities, ids...)
+	}
+
+	if len(identities) == 0 {
+		return nil, errors.New("no age identities available; set " + AgeIdentityFileEnvVar)
+	}
+
+	return identities, nil
+}
+
+func parseAgeRecipients(recipients []string) ([]age.Recipient, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("at least one age recipient is required")
+	}
+
+	parsed := make([]age.Recipient, 0, len(recipients))
+
+	for _, r := range recipients {
+		recipient, err := age.ParseX25519Recipient(strings.TrimSpace(r))
+		if err != nil {
+			return nil, fmt.Errorf("%w; Invalid age recipient: %s", err, r)
+		}
+
+		parsed = append(parsed, recipient)
+	}
+
+	return parsed, nil
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////
+// Key Provider
+
+// ageKeyProvider encrypts the Data Key for a set of age (X25519) recipients, so that the holder of
+// any one of the matching identities is able to decrypt it
+type ageKeyProvider struct {
+	recipients    []age.Recipient
+	identityFiles []string
+}
+
+func newAgeKeyProvider(w *DataKeyWrapping, config ProviderConfig) (KeyProvider, error) {
+	identityFiles := config.AgeIdentityFiles
+	if len(identityFiles) == 0 {
+		identityFiles = defaultAgeIdentityFiles()
+	}
+
+	return createAgeKeyProvider(w.AgeRecipients, identityFiles)
+}
+
+func createAgeKeyProvider(recipients []string, identityFiles []string) (*ageKeyProvider, error) {
+	parsed, err := parseAgeRecipients(recipients)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ageKeyProvider{
+		recipients:    parsed,
+		identityFiles: identityFiles,
+	}, nil
+}
+
+func (p *ageKeyProvider) Name() string {
+	return AgeKeyProviderName
+}
+
+func (p *ageKeyProvider) WrapDataKey(dataKey []byte) ([]byte, error) {
+	var b bytes.Buffer
+
+	w, err := age.Encrypt(&b, p.recipients...)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(dataKey); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+// UnwrapDataKey decrypts the Data Key with the identities loaded from the identity files. These are only
+// read at this point, so that the Data Key can be re-wrapped for new recipients without them.
+func (p *ageKeyProvider) UnwrapDataKey(wrapped []byte) ([]byte, error) {
+	identities, err := loadAgeIdentities(p.identityFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := age.Decrypt(bytes.NewReader(wrapped), identities...)
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.ReadAll(r)
+}
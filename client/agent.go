@@ -0,0 +1,263 @@
+package client
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// The agent keeps unwrapped Data Keys in memory for a limited time, so that subsequent CLI invocations
+// don't have to go to KMS (or ask for a passphrase) every time. It listens on a Unix socket, which is
+// protected by file system permissions - much like ssh-agent.
+
+const AgentSocketEnvVar = "CONFIGSTORE_AGENT_SOCK"
+
+const agentTimeout = time.Second
+
+const (
+	agentOpGet    = "get"
+	agentOpPut    = "put"
+	agentOpLock   = "lock"
+	agentOpUnlock = "unlock"
+)
+
+var errAgentNotFound = errors.New("data key not found in agent")
+
+type agentRequest struct {
+	Op       string `json:"op"`
+	Id       string `json:"id,omitempty"`
+	DataKey  []byte `json:"data_key,omitempty"`
+	Password []byte `json:"password,omitempty"`
+}
+
+type agentResponse struct {
+	DataKey []byte `json:"data_key,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Found   bool   `json:"found,omitempty"`
+}
+
+// agentDataKeyId identifies a copy of the Data Key in the agent. The wrapped Data Key changes whenever
+// the Data Key is rotated or re-wrapped, so stale entries are never used.
+func agentDataKeyId(w *DataKeyWrapping) string {
+	sum := sha256.Sum256([]byte(w.KeyProvider + ":" + w.DataKey))
+	return hex.EncodeToString(sum[:])
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////
+// Server
+
+type agentEntry struct {
+	dataKey []byte
+	expires time.Time
+}
+
+type Agent struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	entries  map[string]agentEntry
+	password []byte // sha256 of the lock password, if the agent is locked
+}
+
+// NewAgent creates an agent which holds on to each Data Key for the given amount of time
+func NewAgent(ttl time.Duration) *Agent {
+	return &Agent{
+		ttl:     ttl,
+		entries: make(map[string]agentEntry),
+	}
+}
+
+// Serve accepts connections on the listener until it's closed
+func (a *Agent) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		go a.handle(conn)
+	}
+}
+
+func (a *Agent) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(agentTimeout))
+
+	var req agentRequest
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&req); err != nil {
+		return
+	}
+
+	res := a.process(req)
+	json.NewEncoder(conn).Encode(res)
+}
+
+func (a *Agent) process(req agentRequest) agentResponse {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.expire()
+
+	switch req.Op {
+	case agentOpLock:
+		if a.password != nil {
+			return agentResponse{Error: "agent is already locked"}
+		}
+
+		if len(req.Password) == 0 {
+			return agentResponse{Error: "password cannot be empty"}
+		}
+
+		sum := sha256.Sum256(req.Password)
+		a.password = sum[:]
+		return agentResponse{}
+	case agentOpUnlock:
+		if a.password == nil {
+			return agentResponse{Error: "agent is not locked"}
+		}
+
+		sum := sha256.Sum256(req.Password)
+		if subtle.ConstantTimeCompare(sum[:], a.password) != 1 {
+			return agentResponse{Error: "incorrect password"}
+		}
+
+		a.password = nil
+		return agentResponse{}
+	}
+
+	if a.password != nil {
+		return agentResponse{Error: "agent is locked"}
+	}
+
+	switch req.Op {
+	case agentOpGet:
+		entry, exists := a.entries[req.Id]
+		if !exists {
+			return agentResponse{}
+		}
+
+		return agentResponse{DataKey: entry.dataKey, Found: true}
+	case agentOpPut:
+		if req.Id == "" || len(req.DataKey) == 0 {
+			return agentResponse{Error: "missing Data Key"}
+		}
+
+		a.entries[req.Id] = agentEntry{
+			dataKey: req.DataKey,
+			expires: time.Now().Add(a.ttl),
+		}
+		return agentResponse{}
+	default:
+		return agentResponse{Error: "unsupported agent operation: " + req.Op}
+	}
+}
+
+// expire drops (and zeroes) every Data Key which has been held for longer than the TTL
+func (a *Agent) expire() {
+	now := time.Now()
+
+	for id, entry := range a.entries {
+		if now.After(entry.expires) {
+			for i := range entry.dataKey {
+				entry.dataKey[i] = 0
+			}
+			delete(a.entries, id)
+		}
+	}
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////
+// Client
+
+type AgentClient struct {
+	socket string
+}
+
+func NewAgentClient(socket string) *AgentClient {
+	return &AgentClient{
+		socket: socket,
+	}
+}
+
+// agentFromEnv returns a client for the agent set via CONFIGSTORE_AGENT_SOCK, or nil if it's not set
+func agentFromEnv() *AgentClient {
+	if socket := os.Getenv(AgentSocketEnvVar); socket != "" {
+		return NewAgentClient(socket)
+	}
+
+	return nil
+}
+
+// WithAgentSocket sets the socket of the agent used for caching Data Keys, instead of the one set via the
+// CONFIGSTORE_AGENT_SOCK environment variable. Passing an empty path disables the agent.
+func WithAgentSocket(socket string) ClientOption {
+	return func(c *ConfigstoreClient) {
+		if socket == "" {
+			c.agent = nil
+		} else {
+			c.agent = NewAgentClient(socket)
+		}
+	}
+}
+
+func (a *AgentClient) call(req agentRequest) (agentResponse, error) {
+	conn, err := net.DialTimeout("unix", a.socket, agentTimeout)
+	if err != nil {
+		return agentResponse{}, fmt.Errorf("%w; Failed to connect to agent", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(agentTimeout))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return agentResponse{}, err
+	}
+
+	var res agentResponse
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&res); err != nil {
+		return agentResponse{}, fmt.Errorf("%w; Failed to read response from agent", err)
+	}
+
+	if res.Error != "" {
+		return agentResponse{}, errors.New(res.Error)
+	}
+
+	return res, nil
+}
+
+// Lock stops the agent from handing out Data Keys, until it's unlocked with the same password
+func (a *AgentClient) Lock(password []byte) error {
+	_, err := a.call(agentRequest{Op: agentOpLock, Password: password})
+	return err
+}
+
+func (a *AgentClient) Unlock(password []byte) error {
+	_, err := a.call(agentRequest{Op: agentOpUnlock, Password: password})
+	return err
+}
+
+func (a *AgentClient) getDataKey(w *DataKeyWrapping) ([]byte, error) {
+	res, err := a.call(agentRequest{Op: agentOpGet, Id: agentDataKeyId(w)})
+	if err != nil {
+		return nil, err
+	}
+
+	if !res.Found {
+		return nil, errAgentNotFound
+	}
+
+	return res.DataKey, nil
+}
+
+func (a *AgentClient) putDataKey(w *DataKeyWrapping, dataKey []byte) error {
+	_, err := a.call(agentRequest{Op: agentOpPut, Id: agentDataKeyId(w), DataKey: dataKey})
+	return err
+}
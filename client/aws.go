@@ -3,30 +3,132 @@ package client
 import (
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/kms"
+	"os"
 )
 
+// AWSSettings holds optional settings for connecting to AWS, on top of the Region and IAM Role
+type AWSSettings struct {
+	// Custom KMS endpoint, like a VPC endpoint, or a local KMS emulator. Only used for KMS; IAM Roles are still
+	// assumed via the regular STS endpoint of the Region.
+	Endpoint string `json:"endpoint,omitempty"`
+	// Named profile from the shared AWS credentials/config files
+	Profile string `json:"profile,omitempty"`
+	// External ID to pass along when assuming the IAM Role
+	ExternalId string `json:"external_id,omitempty"`
+	// Session name to use when assuming the IAM Role
+	SessionName string `json:"session_name,omitempty"`
+	// Serial number (or ARN) of the MFA device required for assuming the IAM Role
+	MFASerial string `json:"mfa_serial,omitempty"`
+}
+
+// merge returns a copy of the settings, with every field which is set in the overrides replaced
+func (s *AWSSettings) merge(overrides AWSSettings) AWSSettings {
+	var merged AWSSettings
+	if s != nil {
+		merged = *s
+	}
+
+	if overrides.Endpoint != "" {
+		merged.Endpoint = overrides.Endpoint
+	}
+
+	if overrides.Profile != "" {
+		merged.Profile = overrides.Profile
+	}
+
+	if overrides.ExternalId != "" {
+		merged.ExternalId = overrides.ExternalId
+	}
+
+	if overrides.SessionName != "" {
+		merged.SessionName = overrides.SessionName
+	}
+
+	if overrides.MFASerial != "" {
+		merged.MFASerial = overrides.MFASerial
+	}
+
+	return merged
+}
+
+// WithAWSOverrides overrides the AWS settings stored in the Configstore DB; only the fields which are set
+// are overridden
+func WithAWSOverrides(settings AWSSettings) ClientOption {
+	return func(c *ConfigstoreClient) {
+		c.providerConfig.AWS = settings
+	}
+}
+
+const MFATokenEnvVar = "CONFIGSTORE_MFA_TOKEN"
+
+// MFATokenFunc is used to ask the user for an MFA token code, when assuming an IAM Role which requires MFA
+type MFATokenFunc func() (string, error)
+
+// WithMFATokenFunc sets the function used to ask for the MFA token code, if one wasn't provided via the
+// CONFIGSTORE_MFA_TOKEN environment variable. The code is only asked for once per client, since a
+// single operation may need to assume more than one IAM Role.
+func WithMFATokenFunc(f MFATokenFunc) ClientOption {
+	return func(c *ConfigstoreClient) {
+		var token string
+
+		c.providerConfig.MFATokenFunc = func() (string, error) {
+			if token == "" {
+				t, err := f()
+				if err != nil {
+					return "", err
+				}
+				token = t
+			}
+
+			return token, nil
+		}
+	}
+}
+
+// mfaTokenProvider returns the MFA token code from the environment if set, or asks for it via the
+// given function otherwise
+func mfaTokenProvider(f MFATokenFunc) func() (string, error) {
+	return func() (string, error) {
+		if token := os.Getenv(MFATokenEnvVar); token != "" {
+			return token, nil
+		}
+
+		if f == nil {
+			return "", errors.New("the IAM Role requires an MFA token code; set " + MFATokenEnvVar)
+		}
+
+		return f()
+	}
+}
+
 type AWS struct {
-	sess *session.Session
+	sess     *session.Session
+	settings AWSSettings
 }
 
 type KMS struct {
 	service *kms.KMS
 }
 
-func createAWSSession(region string, role string) (*AWS, error) {
+func createAWSSession(region string, role string, settings AWSSettings, mfaTokenFunc MFATokenFunc) (*AWS, error) {
 	if region == "" {
 		return nil, errors.New("region cannot be empty when setting up AWS Session")
 	}
 
-	sess, err := session.NewSession(
-		&aws.Config{
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config: aws.Config{
 			Region: aws.String(region),
 		},
-	)
+		Profile: settings.Profile,
+		// Profiles may be defined in ~/.aws/config only (with role_arn and source_profile, or a region), which
+		// is ignored by the SDK unless AWS_SDK_LOAD_CONFIG is set
+		SharedConfigState: session.SharedConfigEnable,
+	})
 
 	if err != nil {
 		return nil, err
@@ -34,7 +136,20 @@ func createAWSSession(region string, role string) (*AWS, error) {
 
 	// If an IAM role is set, replace Session credentials
 	if role != "" {
-		creds := stscreds.NewCredentials(sess, role)
+		creds := stscreds.NewCredentials(sess, role, func(p *stscreds.AssumeRoleProvider) {
+			if settings.ExternalId != "" {
+				p.ExternalID = aws.String(settings.ExternalId)
+			}
+
+			if settings.SessionName != "" {
+				p.RoleSessionName = settings.SessionName
+			}
+
+			if settings.MFASerial != "" {
+				p.SerialNumber = aws.String(settings.MFASerial)
+				p.TokenProvider = mfaTokenProvider(mfaTokenFunc)
+			}
+		})
 
 		if creds == nil {
 			return nil, errors.New("failed to get temporary credentials for IAM Role")
@@ -44,7 +159,8 @@ func createAWSSession(region string, role string) (*AWS, error) {
 	}
 
 	return &AWS{
-		sess: sess,
+		sess:     sess,
+		settings: settings,
 	}, nil
 }
 
@@ -53,23 +169,40 @@ func (a AWS) createKMS() (*KMS, error) {
 		return nil, errors.New("AWS Session must be initialised before services can be created")
 	}
 
+	// The endpoint is only applied to KMS, since the session is also used for assuming IAM Roles via STS
+	config := aws.NewConfig()
+	if a.settings.Endpoint != "" {
+		config = config.WithEndpoint(a.settings.Endpoint)
+	}
+
 	return &KMS{
-		service: kms.New(a.sess),
+		service: kms.New(a.sess, config),
 	}, nil
 }
 
-func (k KMS) generateDataKey(keyId string) (*kms.GenerateDataKeyOutput, error) {
+// kmsEncryptionContext converts an encryption context into the format expected by the SDK
+func kmsEncryptionContext(context map[string]string) map[string]*string {
+	if len(context) == 0 {
+		return nil
+	}
+
+	return aws.StringMap(context)
+}
+
+func (k KMS) generateDataKey(keyId string, context map[string]string) (*kms.GenerateDataKeyOutput, error) {
 	in := kms.GenerateDataKeyInput{
-		KeyId:   aws.String(keyId),
-		KeySpec: aws.String("AES_256"),
+		KeyId:             aws.String(keyId),
+		KeySpec:           aws.String("AES_256"),
+		EncryptionContext: kmsEncryptionContext(context),
 	}
 
 	return k.service.GenerateDataKey(&in)
 }
 
-func (k KMS) decrypt(text []byte) ([]byte, string, error) {
+func (k KMS) decrypt(text []byte, context map[string]string) ([]byte, string, error) {
 	in := &kms.DecryptInput{
-		CiphertextBlob: text,
+		CiphertextBlob:    text,
+		EncryptionContext: kmsEncryptionContext(context),
 	}
 
 	out, err := k.service.Decrypt(in)
@@ -80,16 +213,103 @@ func (k KMS) decrypt(text []byte) ([]byte, string, error) {
 	return out.Plaintext, *out.KeyId, nil
 }
 
-func (k KMS) encrypt(keyId string, text []byte) (string, error) {
+func (k KMS) encrypt(keyId string, text []byte, context map[string]string) ([]byte, error) {
 	in := &kms.EncryptInput{
-		KeyId:     &keyId,
-		Plaintext: text,
+		KeyId:             &keyId,
+		Plaintext:         text,
+		EncryptionContext: kmsEncryptionContext(context),
 	}
 
 	out, err := k.service.Encrypt(in)
+	if err != nil {
+		return nil, err
+	}
+
+	return out.CiphertextBlob, nil
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////
+// Key Provider
+
+// kmsKeyProvider wraps the Data Key with an AWS KMS Master Key. The encryption context (if any) is
+// passed along with every KMS call, and has to match for decryption to succeed.
+type kmsKeyProvider struct {
+	kms               *KMS
+	masterKeyId       string
+	encryptionContext map[string]string
+}
+
+func newKMSKeyProvider(w *DataKeyWrapping, config ProviderConfig) (KeyProvider, error) {
+	return createKMSKeyProvider(w, config)
+}
+
+// If an IAM Role was defined when the Configstore was created, the `IgnoreRole` setting can
+// be used to ignore (not assume) that IAM Role, and instead use the default credentials - this
+// is useful for example on EC2 servers, which cannot assume regular IAM roles, and have to rely
+// on Instance Roles instead (you do however have to make sure that the Instance Role has access
+// to the KMS Key used for the Configstore)
+func createKMSKeyProvider(w *DataKeyWrapping, config ProviderConfig) (*kmsKeyProvider, error) {
+	role := w.Role
+
+	if config.IgnoreRole {
+		role = ""
+	}
+
+	aws, err := createAWSSession(w.Region, role, w.AWS.merge(config.AWS), config.MFATokenFunc)
+	if err != nil {
+		return nil, fmt.Errorf("%w; Failed to initialise AWS Session", err)
+	}
+
+	kms, err := aws.createKMS()
+	if err != nil {
+		return nil, fmt.Errorf("%w; Failed to initialise KMS", err)
+	}
+
+	return &kmsKeyProvider{
+		kms:               kms,
+		masterKeyId:       w.MasterKeyId,
+		encryptionContext: w.EncryptionContext,
+	}, nil
+}
+
+func (p *kmsKeyProvider) Name() string {
+	return KMSKeyProviderName
+}
+
+func (p *kmsKeyProvider) WrapDataKey(dataKey []byte) ([]byte, error) {
+	return p.kms.encrypt(p.masterKeyId, dataKey, p.encryptionContext)
+}
+
+// UnwrapDataKey decrypts the Data Key via KMS. DBs created before version 2 don't record the
+// Master Key, so in that case we hold on to the one reported by KMS.
+func (p *kmsKeyProvider) UnwrapDataKey(wrapped []byte) ([]byte, error) {
+	dataKey, masterKeyId, err := p.kms.decrypt(wrapped, p.encryptionContext)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.masterKeyId == "" {
+		p.masterKeyId = masterKeyId
+	}
+
+	return dataKey, nil
+}
+
+func (p *kmsKeyProvider) GenerateDataKey() ([]byte, []byte, error) {
+	generated, err := p.kms.generateDataKey(p.masterKeyId, p.encryptionContext)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return generated.Plaintext, generated.CiphertextBlob, nil
+}
+
+func (p *kmsKeyProvider) EncryptValue(value []byte) (string, error) {
+	encrypted, err := p.kms.encrypt(p.masterKeyId, value, p.encryptionContext)
 	if err != nil {
 		return "", err
 	}
 
-	return base64.StdEncoding.EncodeToString(out.CiphertextBlob), nil
+	return base64.StdEncoding.EncodeToString(encrypted), nil
 }
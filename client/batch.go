@@ -0,0 +1,348 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A Batch stages changes to the values in a Configstore DB, and applies them all at once when it's
+// committed: the DB is only written a single time, and nothing is written at all if any of the changes
+// fails. Changes are applied on top of the latest version of the DB (under the DB lock) at commit time,
+// so errors about missing keys and the like are only reported by Commit.
+
+const (
+	batchOpSet      = "set"
+	batchOpUnset    = "unset"
+	batchOpEncrypt  = "encrypt"
+	batchOpDecrypt  = "decrypt"
+	batchOpMeta     = "metadata"
+	batchOpRollback = "rollback"
+	batchOpType     = "type"
+)
+
+var errBatchDone = errors.New("batch has already been committed or discarded")
+
+type batchChange struct {
+	op        string
+	key       string
+	rawValue  []byte
+	isSecret  bool
+	isBinary  bool
+	valueType string
+	setType   bool // Whether valueType replaces the declared type of the key
+	metadata  KeyMetadata
+	n         int // The previous value to roll back to
+}
+
+type Batch struct {
+	c       *ConfigstoreClient
+	changes []batchChange
+	done    bool
+}
+
+// Batch starts a new, empty set of changes for this Configstore
+func (c *ConfigstoreClient) Batch() *Batch {
+	return &Batch{
+		c:       c,
+		changes: make([]batchChange, 0),
+	}
+}
+
+func (b *Batch) stage(change batchChange, emptyKeyMsg string) error {
+	if b.done {
+		return errBatchDone
+	}
+
+	if change.key == "" {
+		return errors.New(emptyKeyMsg)
+	}
+
+	b.changes = append(b.changes, change)
+	return nil
+}
+
+// Set stages storing the given value under key, encrypted if isSecret is set
+func (b *Batch) Set(key string, rawValue []byte, isSecret bool, isBinary bool) error {
+	return b.stage(batchChange{
+		op:       batchOpSet,
+		key:      key,
+		rawValue: rawValue,
+		isSecret: isSecret,
+		isBinary: isBinary,
+	}, "you have to specify a non-empty Key to set")
+}
+
+// SetTyped stages storing the given value under key, and declaring its type
+func (b *Batch) SetTyped(key string, rawValue []byte, isSecret bool, valueType string) error {
+	return b.stage(batchChange{
+		op:        batchOpSet,
+		key:       key,
+		rawValue:  rawValue,
+		isSecret:  isSecret,
+		valueType: valueType,
+		setType:   true,
+	}, "you have to specify a non-empty Key to set")
+}
+
+// SetType stages declaring the type of an existing value
+func (b *Batch) SetType(key string, valueType string) error {
+	return b.stage(batchChange{op: batchOpType, key: key, valueType: valueType}, "you have to specify a non-empty Key to set the type of")
+}
+
+// Unset stages removing the given key
+func (b *Batch) Unset(key string) error {
+	return b.stage(batchChange{op: batchOpUnset, key: key}, "you have to specify a non-empty Key to unset")
+}
+
+// Encrypt stages turning the plain text value of the given key into a secret
+func (b *Batch) Encrypt(key string) error {
+	return b.stage(batchChange{op: batchOpEncrypt, key: key}, "you have to specify a non-empty Key to encrypt")
+}
+
+// Decrypt stages turning the secret value of the given key into plain text
+func (b *Batch) Decrypt(key string) error {
+	return b.stage(batchChange{op: batchOpDecrypt, key: key}, "you have to specify a non-empty Key to decrypt")
+}
+
+// SetMetadata stages replacing the description, owner and tags of the given key (the timestamps are kept)
+func (b *Batch) SetMetadata(key string, metadata KeyMetadata) error {
+	return b.stage(batchChange{op: batchOpMeta, key: key, metadata: metadata}, "you have to specify a non-empty Key to set metadata for")
+}
+
+// Rollback stages restoring the nth previous value of the given key (1 being the most recent one)
+func (b *Batch) Rollback(key string, n int) error {
+	return b.stage(batchChange{op: batchOpRollback, key: key, n: n}, "you have to specify a non-empty Key to roll back")
+}
+
+// Len returns the number of staged changes
+func (b *Batch) Len() int {
+	return len(b.changes)
+}
+
+// Discard drops all staged changes, without touching the DB
+func (b *Batch) Discard() {
+	b.changes = nil
+	b.done = true
+}
+
+// Commit applies all staged changes in order, and writes the DB once. If any of the changes fails, the DB
+// is left as it was.
+func (b *Batch) Commit() error {
+	if b.done {
+		return errBatchDone
+	}
+
+	b.done = true
+
+	if len(b.changes) == 0 {
+		return nil
+	}
+
+	c := b.c
+
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	data := make(map[string]ConfigstoreDBValue, len(c.db.Data))
+	for k, v := range c.db.Data {
+		data[k] = v
+	}
+
+	for _, change := range b.changes {
+		if err := c.applyChange(data, change); err != nil {
+			return err
+		}
+	}
+
+	db := c.db
+	db.Data = data
+
+	if err := c.persist(&db, nil); err != nil {
+		return err
+	}
+
+	c.db = db
+	return nil
+}
+
+// plaintextValue returns the value of the given entry, decrypting it first if it's a secret
+func (c *ConfigstoreClient) plaintextValue(key string, entry ConfigstoreDBValue) (string, error) {
+	if !entry.IsSecret {
+		return entry.Value, nil
+	}
+
+	if err := c.initEncryption(); err != nil {
+		return "", err
+	}
+
+	decrypted, err := c.decryptSecret(key, entry.Value)
+	if err != nil {
+		return "", fmt.Errorf("%w; Failed to decrypt value for key: %s", err, key)
+	}
+
+	return decrypted, nil
+}
+
+// applyChange applies a single staged change to the given copy of the DB values
+func (c *ConfigstoreClient) applyChange(data map[string]ConfigstoreDBValue, change batchChange) error {
+	key := change.key
+
+	switch change.op {
+	case batchOpSet:
+		entry, exists := data[key]
+		value := string(change.rawValue)
+
+		// Values have to be valid for the type declared for the key (unless it's being changed)
+		if change.setType {
+			entry.Type = change.valueType
+		}
+
+		if err := validateEntry(key, entry.Type, change.isBinary, value); err != nil {
+			return err
+		}
+
+		if change.isSecret {
+			if err := c.initEncryption(); err != nil {
+				return err
+			}
+
+			encrypted, err := c.encryptSecret(key, change.rawValue)
+			if err != nil {
+				return err
+			}
+
+			value = encrypted
+		}
+
+		now := time.Now().UTC().Truncate(time.Second)
+		if exists {
+			entry.History = entry.pushHistory(now)
+		} else {
+			entry.CreatedAt = &now
+		}
+
+		// Previous values of a secret must not be left around in plain text
+		if change.isSecret {
+			history, err := c.encryptHistory(key, entry.History)
+			if err != nil {
+				return err
+			}
+
+			entry.History = history
+		}
+
+		entry.UpdatedAt = &now
+		entry.Value = value
+		entry.IsSecret = change.isSecret
+		entry.IsBinary = change.isBinary
+		data[key] = entry
+	case batchOpUnset:
+		delete(data, key)
+	case batchOpEncrypt:
+		entry, exists := data[key]
+		if !exists {
+			return errors.New("key does not exist in Configstore: " + key)
+		}
+
+		// Already encrypted - leave alone
+		if entry.IsSecret {
+			return nil
+		}
+
+		if err := c.initEncryption(); err != nil {
+			return err
+		}
+
+		encrypted, err := c.encryptSecret(key, []byte(entry.Value))
+		if err != nil {
+			return err
+		}
+
+		history, err := c.encryptHistory(key, entry.History)
+		if err != nil {
+			return err
+		}
+
+		entry.Value = encrypted
+		entry.IsSecret = true
+		entry.History = history
+		data[key] = entry
+	case batchOpDecrypt:
+		entry, exists := data[key]
+		if !exists {
+			return errors.New("key does not exist in Configstore: " + key)
+		}
+
+		// Already plain text - leave alone
+		if !entry.IsSecret {
+			return nil
+		}
+
+		if err := c.initEncryption(); err != nil {
+			return err
+		}
+
+		decrypted, err := c.decryptSecret(key, entry.Value)
+		if err != nil {
+			return fmt.Errorf("%w; Failed to decrypt value for key: %s", err, key)
+		}
+
+		history, err := c.decryptHistory(key, entry.History)
+		if err != nil {
+			return err
+		}
+
+		entry.Value = decrypted
+		entry.IsSecret = false
+		entry.History = history
+		data[key] = entry
+	case batchOpMeta:
+		entry, exists := data[key]
+		if !exists {
+			return errors.New("key does not exist in Configstore: " + key)
+		}
+
+		entry.Description = strings.TrimSpace(change.metadata.Description)
+		entry.Owner = strings.TrimSpace(change.metadata.Owner)
+		entry.Tags = normaliseTags(change.metadata.Tags)
+		data[key] = entry
+	case batchOpType:
+		entry, exists := data[key]
+		if !exists {
+			return errors.New("key does not exist in Configstore: " + key)
+		}
+
+		value, err := c.plaintextValue(key, entry)
+		if err != nil {
+			return err
+		}
+
+		if err := validateEntry(key, change.valueType, entry.IsBinary, value); err != nil {
+			return err
+		}
+
+		entry.Type = change.valueType
+		data[key] = entry
+	case batchOpRollback:
+		entry, exists := data[key]
+		if !exists {
+			return errors.New("key does not exist in Configstore: " + key)
+		}
+
+		entry, err := c.applyRollback(key, entry, change.n)
+		if err != nil {
+			return err
+		}
+
+		data[key] = entry
+	default:
+		return errors.New("unsupported batch operation: " + change.op)
+	}
+
+	return nil
+}
@@ -6,16 +6,41 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"reflect"
 	"strings"
 	"text/template"
 )
 
 type ConfigstoreClient struct {
-	dbFile     string
-	db         ConfigstoreDB
-	encryption *Encryption
-	ignoreRole bool
-	overrides  map[string]string
+	storage        Storage
+	db             ConfigstoreDB
+	encryption     *Encryption
+	provider       KeyProvider
+	providerConfig ProviderConfig
+	agent          *AgentClient
+	overrides      map[string]string
+	unlockDB       func() error // Only set while the DB is locked for a load-modify-save cycle
+	// The version of the DB in storage which the client last read or wrote (see Storage)
+	dbVersion string
+
+	requireIntegrity bool
+	// The integrity tag and canonical contents of the DB as it was loaded, which are verified before
+	// the DB is first saved, so that changes made outside of Configstore aren't signed off on by accident
+	loadedIntegrity string
+	loadedCanonical []byte
+	// The version of the DB file, if it predates the latest version, and was only upgraded in memory when loaded
+	migratedFrom int
+}
+
+// ClientOption is used to customise a ConfigstoreClient when it's created
+type ClientOption func(*ConfigstoreClient)
+
+// WithKeyProvider makes the client use the given KeyProvider for unwrapping the Data Key, instead
+// of creating one based on the provider recorded in the Configstore DB
+func WithKeyProvider(provider KeyProvider) ClientOption {
+	return func(c *ConfigstoreClient) {
+		c.provider = provider
+	}
 }
 
 ///////////////////////////////////////////////////////////////////////////////////////////////////
@@ -59,39 +84,254 @@ func loadOverride(path string) (map[string]string, error) {
 	return overrides, nil
 }
 
+// initKeyProvider sets up the KeyProvider for the main copy of the Data Key
+func (c *ConfigstoreClient) initKeyProvider() error {
+	if c.provider == nil {
+		w := c.db.primaryDataKey()
+
+		provider, err := createKeyProvider(&w, c.providerConfig)
+		if err != nil {
+			return err
+		}
+
+		c.provider = provider
+	}
+
+	return nil
+}
+
+// createKMSDataKeyProvider sets up a KMS KeyProvider for a new copy of the Data Key. The IAM Role is
+// always assumed in this case, since it was provided explicitly.
+func (c *ConfigstoreClient) createKMSDataKeyProvider(w *DataKeyWrapping) (*kmsKeyProvider, error) {
+	return createKMSKeyProvider(w, ProviderConfig{
+		AWS:          c.providerConfig.AWS,
+		MFATokenFunc: c.providerConfig.MFATokenFunc,
+	})
+}
+
+// unwrapDataKey tries to unwrap the Data Key via the given copy. Index 0 is the main copy.
+// If an agent is available, it's asked for the Data Key first, and the KeyProvider is only
+// used (and the result handed to the agent) if it doesn't have it.
+func (c *ConfigstoreClient) unwrapDataKey(index int, w *DataKeyWrapping) (*Encryption, error) {
+	if c.agent != nil {
+		if dataKey, err := c.agent.getDataKey(w); err == nil {
+			return &Encryption{
+				dataKey:  dataKey,
+				provider: nil, // Only needed by GetAsKMSEncrypted, which sets it up on demand
+			}, nil
+		}
+	}
+
+	var provider KeyProvider
+
+	if index == 0 {
+		if err := c.initKeyProvider(); err != nil {
+			return nil, err
+		}
+
+		provider = c.provider
+	} else {
+		p, err := createKeyProvider(w, c.providerConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		provider = p
+	}
+
+	enc, err := createEncryption(w, provider)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.agent != nil {
+		// The agent is only an optimisation, so failing to reach it is not an error
+		c.agent.putDataKey(w, enc.dataKey)
+	}
+
+	return enc, nil
+}
+
+// initEncryption unwraps the Data Key, trying each copy of it in order until one succeeds
 func (c *ConfigstoreClient) initEncryption() error {
 	if c.encryption == nil {
-		enc, err := createEncryption(&c.db, nil, c.ignoreRole)
-		if err != nil {
-			return fmt.Errorf("%w; Failed to initialise encryption library", err)
+		wrappings := c.db.dataKeyWrappings()
+		errs := make([]string, 0)
+
+		for i, w := range wrappings {
+			enc, err := c.unwrapDataKey(i, &w)
+			if err == nil {
+				c.encryption = enc
+				return nil
+			}
+
+			if len(wrappings) == 1 {
+				return fmt.Errorf("%w; Failed to initialise encryption library", err)
+			}
+
+			errs = append(errs, fmt.Sprintf("[%d] %s: %s", i, w.KeyProvider, err))
 		}
 
-		c.encryption = enc
-		return nil
+		return errors.New("failed to decrypt any copy of the Data Key: " + strings.Join(errs, ", "))
 	} else {
 		return nil
 	}
 }
 
-func (c ConfigstoreClient) dbContainsEncrypted() bool {
-	for _, v := range c.db.Data {
+// reencryptSecrets decrypts every secret in the DB with the current Data Key, and encrypts it again
+// using the provided Encryption. The result is returned as a new map, so that the DB held by the
+// client is left untouched if anything goes wrong half way.
+func (c *ConfigstoreClient) reencryptSecrets(to *Encryption) (map[string]ConfigstoreDBValue, error) {
+	data := make(map[string]ConfigstoreDBValue, len(c.db.Data))
+
+	for k, v := range c.db.Data {
 		if v.IsSecret {
-			return true
+			decrypted, err := c.decryptSecret(k, v.Value)
+			if err != nil {
+				return nil, fmt.Errorf("%w; Failed to decrypt value for key: %s", err, k)
+			}
+
+			encrypted, err := to.encrypt([]byte(decrypted), c.db.secretAAD(k))
+			if err != nil {
+				return nil, fmt.Errorf("%w; Failed to encrypt value for key: %s", err, k)
+			}
+
+			v.Value = encrypted
+		}
+
+		history := make([]HistoryEntry, len(v.History))
+
+		for i, h := range v.History {
+			if h.IsSecret {
+				decrypted, err := c.decryptSecret(k, h.Value)
+				if err != nil {
+					return nil, fmt.Errorf("%w; Failed to decrypt previous value %d for key: %s", err, i+1, k)
+				}
+
+				encrypted, err := to.encrypt([]byte(decrypted), c.db.secretAAD(k))
+				if err != nil {
+					return nil, fmt.Errorf("%w; Failed to encrypt previous value %d for key: %s", err, i+1, k)
+				}
+
+				h.Value = encrypted
+			}
+
+			history[i] = h
 		}
+
+		if len(history) > 0 {
+			v.History = history
+		}
+
+		data[k] = v
 	}
 
-	return false
+	return data, nil
+}
+
+// decryptSecret decrypts the value stored under the given key. Values in a format older than the DB allows
+// are rejected (see Encryption.decrypt), since there's no way to tell whether they were moved there from another key.
+func (c *ConfigstoreClient) decryptSecret(key string, value string) (string, error) {
+	return c.encryption.decrypt(value, c.db.secretAAD(key), c.db.minCiphertextVersion())
+}
+
+func (c *ConfigstoreClient) encryptSecret(key string, value []byte) (string, error) {
+	return c.encryption.encrypt(value, c.db.secretAAD(key))
+}
+
+// validateOverride checks that the given key can be overridden with the given value
+func validateOverride(db ConfigstoreDB, key string, value string) error {
+	mainVal, exists := db.Data[key]
+	if !exists {
+		return errors.New("override key doesn't exist in Configstore DB: " + key)
+	}
+
+	if mainVal.IsSecret {
+		return errors.New("trying to override key with secret value: " + key)
+	}
+
+	if err := validateEntry(key, mainVal.Type, mainVal.IsBinary, value); err != nil {
+		return fmt.Errorf("%w; Invalid override", err)
+	}
+
+	return nil
+}
+
+// lock takes the lock on the DB file for a load-modify-save cycle, and reloads the DB, so that changes are
+// always made on top of its latest version. The returned function releases the lock. Nested calls (one
+// public method calling another) share the lock taken by the outermost one.
+func (c *ConfigstoreClient) lock() (func(), error) {
+	if c.unlockDB != nil {
+		return func() {}, nil
+	}
+
+	unlockDB, err := c.storage.Lock()
+	if err != nil {
+		return nil, err
+	}
+
+	c.unlockDB = unlockDB
+	unlock := func() {
+		c.unlockDB = nil
+		unlockDB()
+	}
+
+	db, version, err := readDB(c.storage)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+
+	c.dbVersion = version
+
+	if err := c.load(db); err != nil {
+		unlock()
+		return nil, err
+	}
+
+	return unlock, nil
 }
 
-func (c *ConfigstoreClient) ensureLatestVersion() error {
-	if c.db.Version == 1 {
-		if err := c.migrateToV2(); err != nil {
+// load makes the given DB (as read from the DB file) the one held by the client, and upgrades it (in memory)
+// as far as that can be done without the Data Key (see migrations.go)
+func (c *ConfigstoreClient) load(db ConfigstoreDB) error {
+	// Another process may have replaced or re-wrapped the Data Key since the DB was last loaded
+	if !reflect.DeepEqual(c.db.dataKeyWrappings(), db.dataKeyWrappings()) {
+		c.encryption = nil
+
+		current, latest := c.db.primaryDataKey(), db.primaryDataKey()
+		current.DataKey, latest.DataKey = "", ""
+
+		if !reflect.DeepEqual(current, latest) {
+			c.provider = nil
+		}
+	}
+
+	c.db = db
+	c.loadedIntegrity = ""
+	c.loadedCanonical = nil
+
+	if db.Integrity != "" {
+		canonical, err := integrityCanonical(db)
+		if err != nil {
 			return err
 		}
+
+		c.loadedIntegrity = db.Integrity
+		c.loadedCanonical = canonical
+	}
+
+	c.migratedFrom = 0
+	if db.Version < latestVersion {
+		c.migratedFrom = db.Version
+	}
+
+	if err := c.migrate(false); err != nil {
+		return err
 	}
 
-	if c.db.Version == 2 {
-		if err := c.migrateToV3(); err != nil {
+	if c.requireIntegrity {
+		if err := c.Verify(); err != nil {
 			return err
 		}
 	}
@@ -99,21 +339,119 @@ func (c *ConfigstoreClient) ensureLatestVersion() error {
 	return nil
 }
 
-func (c *ConfigstoreClient) migrateToV2() error {
-	err := c.initEncryption()
+// save writes the DB held by the client to the DB file
+func (c *ConfigstoreClient) save() error {
+	return c.persist(&c.db, nil)
+}
+
+// persist writes the given DB to the DB file. If the DB is protected by an integrity tag, the tag is
+// recomputed first, using the Data Key of the given Encryption (or the current one if nil).
+func (c *ConfigstoreClient) persist(db *ConfigstoreDB, enc *Encryption) error {
+	if c.migratedFrom != 0 {
+		return fmt.Errorf("the Configstore DB file is at version %d, and has to be migrated before it can be changed (run: configstore migrate)", c.migratedFrom)
+	}
+
+	if db.Integrity == "" && c.loadedIntegrity == "" {
+		return c.write(*db)
+	}
+
+	if err := c.initEncryption(); err != nil {
+		return err
+	}
+
+	if c.loadedCanonical != nil {
+		if err := verifyIntegrityTag(c.loadedCanonical, c.loadedIntegrity, c.encryption.dataKey); err != nil {
+			return fmt.Errorf("%w; Refusing to save Configstore DB", err)
+		}
+
+		c.loadedCanonical = nil // No need to check again
+	}
+
+	if db.Integrity != "" {
+		if enc == nil {
+			enc = c.encryption
+		}
+
+		canonical, err := integrityCanonical(*db)
+		if err != nil {
+			return err
+		}
+
+		tag, err := computeIntegrityTag(canonical, enc.dataKey)
+		if err != nil {
+			return err
+		}
+
+		db.Integrity = tag
+	}
+
+	return c.write(*db)
+}
+
+// write saves the given DB to storage, as long as it hasn't been changed since the client last loaded it
+func (c *ConfigstoreClient) write(db ConfigstoreDB) error {
+	version, err := writeDB(c.storage, db, c.dbVersion)
 	if err != nil {
 		return err
 	}
 
-	c.db.MasterKeyId = c.encryption.masterKeyId
-	c.db.Version = 2
-	return saveDB(c.dbFile, c.db)
+	c.dbVersion = version
+	return nil
+}
+
+func (c ConfigstoreClient) dbContainsEncrypted() bool {
+	for _, v := range c.db.Data {
+		if v.IsSecret {
+			return true
+		}
+	}
+
+	return false
 }
 
-func (c *ConfigstoreClient) migrateToV3() error {
-	c.db.Version = 3
-	// New attributes will be filled in via zero values
-	return saveDB(c.dbFile, c.db)
+// upgradeSecrets re-encrypts every secret stored in the given (or an older) ciphertext format,
+// using the latest format. This is only safe to do as part of a migration, where we trust the
+// DB to be in the state it was last written in.
+func (c *ConfigstoreClient) upgradeSecrets(upToVersion int) error {
+	if !c.dbContainsEncrypted() {
+		return nil
+	}
+
+	if err := c.initEncryption(); err != nil {
+		return err
+	}
+
+	for k, v := range c.db.Data {
+		if !v.IsSecret {
+			continue
+		}
+
+		version, err := ciphertextVersion(v.Value)
+		if err != nil {
+			return fmt.Errorf("%w; Failed to migrate value for key: %s", err, k)
+		}
+
+		if version > upToVersion {
+			continue
+		}
+
+		// The identity of the DB is generated by the migration itself (see migrate), so the formats being
+		// upgraded are accepted regardless
+		decrypted, err := c.encryption.decrypt(v.Value, nil, ciphertextV1)
+		if err != nil {
+			return fmt.Errorf("%w; Failed to decrypt value for key: %s", err, k)
+		}
+
+		encrypted, err := c.encryptSecret(k, []byte(decrypted))
+		if err != nil {
+			return err
+		}
+
+		v.Value = encrypted
+		c.db.Data[k] = v
+	}
+
+	return nil
 }
 
 ///////////////////////////////////////////////////////////////////////////////////////////////////
@@ -136,7 +474,7 @@ func (c *ConfigstoreClient) Get(key string) (string, error) {
 			return "", err
 		}
 
-		decrypted, err := c.encryption.decrypt(entry.Value)
+		decrypted, err := c.decryptSecret(key, entry.Value)
 		if err != nil {
 			return "", fmt.Errorf("%w; Failed to decrypt value for key: %s", err, key)
 		}
@@ -172,12 +510,23 @@ func (c *ConfigstoreClient) GetAsKMSEncrypted(key string) (string, error) {
 		return "", err
 	}
 
-	encrypted, err := c.encryption.kms.encrypt(c.db.MasterKeyId, []byte(value))
-	if err != nil {
-		return "", err
+	provider := c.encryption.provider
+
+	// The Data Key may have come from the agent, in which case we still need the provider itself
+	if provider == nil {
+		if err := c.initKeyProvider(); err != nil {
+			return "", err
+		}
+
+		provider = c.provider
+	}
+
+	encrypter, ok := provider.(ValueEncrypter)
+	if !ok {
+		return "", errors.New("key provider does not support encrypting values: " + provider.Name())
 	}
 
-	return encrypted, nil
+	return encrypter.EncryptValue([]byte(value))
 }
 
 func (c *ConfigstoreClient) GetAll(skipDecryption bool) (map[string]ConfigstoreDBValue, error) {
@@ -190,32 +539,28 @@ func (c *ConfigstoreClient) GetAll(skipDecryption bool) (map[string]ConfigstoreD
 	entries := make(map[string]ConfigstoreDBValue, len(c.db.Data))
 
 	for k, v := range c.db.Data {
+		v.History = nil // Previous values are only available via History
+
 		if v.IsSecret {
 			var value string
 
 			if skipDecryption {
 				value = "(secret)"
 			} else {
-				decoded, err := c.encryption.decrypt(v.Value)
+				decoded, err := c.decryptSecret(k, v.Value)
 				if err != nil {
 					return nil, fmt.Errorf("%w; Failed to decrypt value for key: %s", err, k)
 				}
 				value = decoded
 			}
 
-			entries[k] = ConfigstoreDBValue{
-				Value:    value,
-				IsSecret: v.IsSecret,
-				IsBinary: v.IsBinary,
-			}
+			v.Value = value
+			entries[k] = v
 		} else {
 			o, exists := c.overrides[k]
 			if exists {
-				entries[k] = ConfigstoreDBValue{
-					Value:    o,
-					IsSecret: v.IsSecret,
-					IsBinary: v.IsBinary,
-				}
+				v.Value = o
+				entries[k] = v
 			} else {
 				entries[k] = v
 			}
@@ -256,133 +601,625 @@ func (c *ConfigstoreClient) GetAllKeys(keyFilter string) []string {
 	return keys
 }
 
+// Set stores a value under the given key, encrypting it first if it's a secret
 func (c *ConfigstoreClient) Set(key string, rawValue []byte, isSecret bool, isBinary bool) error {
-	if key == "" {
-		return errors.New("you have to specify a non-empty Key to set")
+	b := c.Batch()
+	if err := b.Set(key, rawValue, isSecret, isBinary); err != nil {
+		return err
 	}
 
-	var value string
+	return b.Commit()
+}
 
-	if isSecret {
-		err := c.initEncryption()
-		if err != nil {
-			return err
-		}
+// GetType returns the declared type of the given key, or an empty string if it's untyped
+func (c *ConfigstoreClient) GetType(key string) (string, error) {
+	entry, exists := c.db.Data[key]
+	if !exists {
+		return "", errors.New("key does not exist in Configstore: " + key)
+	}
 
-		encrypted, err := c.encryption.encrypt(rawValue)
-		if err != nil {
-			return err
-		}
+	return entry.Type, nil
+}
 
-		value = encrypted
-	} else {
-		value = string(rawValue)
-	}
+// ValidateOverride checks that the given value could be used to override the given key via an override file
+func (c *ConfigstoreClient) ValidateOverride(key string, value string) error {
+	return validateOverride(c.db, key, value)
+}
 
-	c.db.Data[key] = ConfigstoreDBValue{
-		Value:    value,
-		IsSecret: isSecret,
-		IsBinary: isBinary,
+// GetMetadata returns the metadata (description, owner, tags and timestamps) of the given key
+func (c *ConfigstoreClient) GetMetadata(key string) (KeyMetadata, error) {
+	entry, exists := c.db.Data[key]
+	if !exists {
+		return KeyMetadata{}, errors.New("key does not exist in Configstore: " + key)
 	}
 
-	err := saveDB(c.dbFile, c.db)
-	if err != nil {
+	return entry.KeyMetadata, nil
+}
+
+// SetMetadata replaces the description, owner and tags of the given key. The timestamps are left alone,
+// since they track changes to the value.
+func (c *ConfigstoreClient) SetMetadata(key string, metadata KeyMetadata) error {
+	b := c.Batch()
+	if err := b.SetMetadata(key, metadata); err != nil {
 		return err
 	}
 
-	return nil
+	return b.Commit()
 }
 
-func (c *ConfigstoreClient) Encrypt(key string) error {
+// Generate creates a new random value with the named generator, and stores it as a secret under the given key.
+// For keypairs, the public key is also stored as a plain text value, under the same key with PublicKeySuffix
+// appended. Existing keys are only replaced if overwrite is set.
+func (c *ConfigstoreClient) Generate(key string, generator string, opts GeneratorOptions, overwrite bool) error {
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	if key == "" {
-		return errors.New("you have to specify a non-empty Key to encrypt")
+		return errors.New("you have to specify a non-empty Key to generate a value for")
 	}
 
-	entry, exists := c.db.Data[key]
-	if !exists {
-		return errors.New("key does not exist in Configstore: " + key)
+	generated, err := GenerateValue(generator, opts)
+	if err != nil {
+		return err
 	}
 
-	// Already encrypted - leave alone
-	if entry.IsSecret {
-		return nil
+	keys := []string{key}
+	if generated.PublicKey != nil {
+		keys = append(keys, key+PublicKeySuffix)
 	}
 
-	err := c.initEncryption()
-	if err != nil {
-		return err
+	if !overwrite {
+		for _, k := range keys {
+			if c.Exists(k) {
+				return errors.New("key already exists in Configstore: " + k)
+			}
+		}
 	}
 
-	encrypted, err := c.encryption.encrypt([]byte(entry.Value))
-	if err != nil {
+	b := c.Batch()
+
+	if err := b.Set(key, generated.Value, true, false); err != nil {
 		return err
 	}
 
-	c.db.Data[key] = ConfigstoreDBValue{
-		Value:    string(encrypted),
-		IsSecret: true,
-		IsBinary: entry.IsBinary,
+	if generated.PublicKey != nil {
+		if err := b.Set(key+PublicKeySuffix, generated.PublicKey, false, false); err != nil {
+			return err
+		}
 	}
 
-	err = saveDB(c.dbFile, c.db)
-	if err != nil {
+	return b.Commit()
+}
+
+func (c *ConfigstoreClient) Encrypt(key string) error {
+	b := c.Batch()
+	if err := b.Encrypt(key); err != nil {
 		return err
 	}
 
-	return nil
+	return b.Commit()
 }
 
 func (c *ConfigstoreClient) Decrypt(key string) error {
-	if key == "" {
-		return errors.New("you have to specify a non-empty Key to decrypt")
+	b := c.Batch()
+	if err := b.Decrypt(key); err != nil {
+		return err
 	}
 
-	entry, exists := c.db.Data[key]
-	if !exists {
-		return errors.New("key does not exist in Configstore: " + key)
+	return b.Commit()
+}
+
+func (c *ConfigstoreClient) Unset(key string) error {
+	b := c.Batch()
+	if err := b.Unset(key); err != nil {
+		return err
 	}
 
-	// Already plain text - leave alone
-	if !entry.IsSecret {
-		return nil
+	return b.Commit()
+}
+
+// Verify checks that the Configstore DB hasn't been modified outside of Configstore, using its integrity tag
+func (c *ConfigstoreClient) Verify() error {
+	if c.db.Integrity == "" {
+		return errors.New("this Configstore is not protected by an integrity tag")
 	}
 
-	err := c.initEncryption()
-	if err != nil {
+	if err := c.initEncryption(); err != nil {
 		return err
 	}
 
-	decrypted, err := c.encryption.decrypt(entry.Value)
+	canonical, err := integrityCanonical(c.db)
 	if err != nil {
-		return fmt.Errorf("%w; Failed to decrypt value for key: %s", err, key)
+		return err
 	}
 
-	c.db.Data[key] = ConfigstoreDBValue{
-		Value:    decrypted,
-		IsSecret: false,
-		IsBinary: entry.IsBinary,
-	}
+	return verifyIntegrityTag(canonical, c.db.Integrity, c.encryption.dataKey)
+}
 
-	err = saveDB(c.dbFile, c.db)
+// EnableIntegrity protects the Configstore DB with an integrity tag, which is recomputed whenever the DB is
+// saved (so the Data Key has to be decrypted every time)
+func (c *ConfigstoreClient) EnableIntegrity() error {
+	unlock, err := c.lock()
 	if err != nil {
 		return err
 	}
+	defer unlock()
 
-	return nil
-}
-
-func (c *ConfigstoreClient) Unset(key string) error {
-	if key == "" {
-		return errors.New("you have to specify a non-empty Key to unset")
+	if c.db.Integrity != "" {
+		return errors.New("this Configstore is already protected by an integrity tag")
 	}
 
-	delete(c.db.Data, key)
+	db := c.db
+	db.Integrity = integrityTagPrefix // Marks the DB as protected; the actual tag is computed when saving
 
-	err := saveDB(c.dbFile, c.db)
-	if err != nil {
+	if err := c.persist(&db, nil); err != nil {
 		return err
 	}
 
+	c.db = db
+	return nil
+}
+
+// DisableIntegrity removes the integrity tag from the Configstore DB, after checking that it's still valid
+func (c *ConfigstoreClient) DisableIntegrity() error {
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if c.db.Integrity == "" {
+		return errors.New("this Configstore is not protected by an integrity tag")
+	}
+
+	if err := c.Verify(); err != nil {
+		return err
+	}
+
+	db := c.db
+	db.Integrity = ""
+
+	if err := c.persist(&db, nil); err != nil {
+		return err
+	}
+
+	c.db = db
+	return nil
+}
+
+// RotateDataKey generates a brand new Data Key for this Configstore, and re-encrypts every secret
+// with it. The new key is generated via the same KeyProvider (so the same KMS Master Key for example),
+// and every additional copy is re-wrapped with its own provider.
+// The DB is only written once all secrets have been re-encrypted successfully.
+func (c *ConfigstoreClient) RotateDataKey() error {
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := c.initEncryption(); err != nil {
+		return err
+	}
+
+	// The Data Key may have been unwrapped via one of the additional copies
+	if err := c.initKeyProvider(); err != nil {
+		return err
+	}
+
+	return c.replaceDataKey(c.provider, c.db.primaryDataKey(), true)
+}
+
+// Secure turns an insecure Configstore into a KMS-backed one, by generating a new Data Key via the
+// given KMS Master Key, and re-encrypting every secret with it
+func (c *ConfigstoreClient) Secure(region string, role string, masterKey string) error {
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if keyProviderName(&c.db) != InsecureKeyProviderName {
+		return errors.New("this Configstore is already secure")
+	}
+
+	if masterKey == "" {
+		return errors.New("you have to specify a non-empty Master Key to secure the Configstore with")
+	}
+
+	w := DataKeyWrapping{
+		Region:            region,
+		Role:              role,
+		MasterKeyId:       masterKey,
+		EncryptionContext: c.db.EncryptionContext,
+		AWS:               c.db.AWS,
+	}
+
+	provider, err := c.createKMSDataKeyProvider(&w)
+	if err != nil {
+		return err
+	}
+
+	return c.replaceDataKey(provider, w, true)
+}
+
+// MakeInsecure turns a Configstore into an insecure one, by generating a new Data Key which is stored
+// in plain text, and re-encrypting every secret with it. Any additional copies of the Data Key are dropped.
+// This is meant for creating local Dev copies of a Configstore - never use it on the original!
+func (c *ConfigstoreClient) MakeInsecure() error {
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if keyProviderName(&c.db) == InsecureKeyProviderName {
+		return errors.New("this Configstore is already insecure")
+	}
+
+	return c.replaceDataKey(insecureKeyProvider{}, DataKeyWrapping{}, false)
+}
+
+// replaceDataKey generates a new Data Key via the given provider, which then becomes the main copy (using the
+// settings from primary). Additional copies are either re-wrapped with the new Data Key, or dropped.
+// All secrets are re-encrypted, and the DB is only written once that has succeeded.
+func (c *ConfigstoreClient) replaceDataKey(provider KeyProvider, primary DataKeyWrapping, keepAdditional bool) error {
+	if err := c.initEncryption(); err != nil {
+		return err
+	}
+
+	plaintext, wrapped, err := generateDataKey(provider)
+	if err != nil {
+		return fmt.Errorf("%w; Failed to generate new Data Key", err)
+	}
+
+	var additional []DataKeyWrapping
+
+	if keepAdditional {
+		for i, w := range c.db.AdditionalDataKeys {
+			p, err := createKeyProvider(&w, c.providerConfig)
+			if err != nil {
+				return fmt.Errorf("%w; Failed to initialise key provider for copy %d of the Data Key", err, i+1)
+			}
+
+			wrappedCopy, err := p.WrapDataKey(plaintext)
+			if err != nil {
+				return fmt.Errorf("%w; Failed to wrap copy %d of the Data Key", err, i+1)
+			}
+
+			w.DataKey = base64.StdEncoding.EncodeToString(wrappedCopy)
+			additional = append(additional, w)
+		}
+	}
+
+	enc := &Encryption{
+		dataKey:  plaintext,
+		provider: provider,
+	}
+
+	data, err := c.reencryptSecrets(enc)
+	if err != nil {
+		return err
+	}
+
+	primary.KeyProvider = provider.Name()
+	primary.DataKey = base64.StdEncoding.EncodeToString(wrapped)
+
+	db := c.db
+	db.setPrimaryDataKey(primary)
+	db.AdditionalDataKeys = additional
+	db.Data = data
+
+	if err := c.persist(&db, enc); err != nil {
+		return err
+	}
+
+	c.db = db
+	c.provider = provider
+	c.encryption = enc
+	return nil
+}
+
+// RewrapDataKey re-encrypts the existing Data Key with a different KMS Master Key, optionally in a
+// different AWS Region and/or via a different IAM Role. Empty values for region and role mean that the
+// current settings are kept. Secret values are left untouched, since the Data Key itself doesn't change.
+func (c *ConfigstoreClient) RewrapDataKey(masterKey string, region string, role string) error {
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if keyProviderName(&c.db) != KMSKeyProviderName {
+		return errors.New("can only re-wrap the Data Key of a KMS-backed Configstore")
+	}
+
+	if masterKey == "" {
+		return errors.New("you have to specify a non-empty Master Key to re-wrap the Data Key with")
+	}
+
+	if region == "" {
+		region = c.db.Region
+	}
+
+	if role == "" {
+		role = c.db.Role
+	}
+
+	if err := c.initEncryption(); err != nil {
+		return err
+	}
+
+	provider, err := c.createKMSDataKeyProvider(&DataKeyWrapping{
+		Region:            region,
+		Role:              role,
+		MasterKeyId:       masterKey,
+		EncryptionContext: c.db.EncryptionContext,
+		AWS:               c.db.AWS,
+	})
+	if err != nil {
+		return err
+	}
+
+	wrapped, err := provider.WrapDataKey(c.encryption.dataKey)
+	if err != nil {
+		return fmt.Errorf("%w; Failed to encrypt Data Key with Master Key: %s", err, masterKey)
+	}
+
+	db := c.db
+	db.DataKey = base64.StdEncoding.EncodeToString(wrapped)
+	db.MasterKeyId = masterKey
+	db.Region = region
+	db.Role = role
+
+	if err := c.persist(&db, nil); err != nil {
+		return err
+	}
+
+	c.db = db
+	c.provider = provider
+	c.encryption = &Encryption{
+		dataKey:  c.encryption.dataKey,
+		provider: provider,
+	}
+
+	return nil
+}
+
+// AddAgeRecipient gives the holder of the identity matching the given age recipient access to this
+// Configstore, by re-wrapping the Data Key for the extended set of recipients
+func (c *ConfigstoreClient) AddAgeRecipient(recipient string) error {
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	recipient = strings.TrimSpace(recipient)
+
+	for _, r := range c.db.AgeRecipients {
+		if r == recipient {
+			return errors.New("age recipient already has access to Configstore: " + recipient)
+		}
+	}
+
+	return c.setAgeRecipients(append(append([]string{}, c.db.AgeRecipients...), recipient))
+}
+
+// RemoveAgeRecipient re-wraps the Data Key without the given age recipient. Note that this doesn't
+// change the Data Key itself - use RotateDataKey afterwards if the recipient should lose access completely.
+func (c *ConfigstoreClient) RemoveAgeRecipient(recipient string) error {
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	recipient = strings.TrimSpace(recipient)
+	recipients := make([]string, 0)
+
+	for _, r := range c.db.AgeRecipients {
+		if r != recipient {
+			recipients = append(recipients, r)
+		}
+	}
+
+	if len(recipients) == len(c.db.AgeRecipients) {
+		return errors.New("age recipient doesn't have access to Configstore: " + recipient)
+	}
+
+	if len(recipients) == 0 {
+		return errors.New("cannot remove the last age recipient from Configstore")
+	}
+
+	return c.setAgeRecipients(recipients)
+}
+
+func (c *ConfigstoreClient) setAgeRecipients(recipients []string) error {
+	if keyProviderName(&c.db) != AgeKeyProviderName {
+		return errors.New("can only manage age recipients for an age-backed Configstore")
+	}
+
+	if err := c.initEncryption(); err != nil {
+		return err
+	}
+
+	provider, err := createAgeKeyProvider(recipients, nil)
+	if err != nil {
+		return err
+	}
+
+	// Hold on to the identities, in case the Data Key needs to be unwrapped again
+	if current, ok := c.provider.(*ageKeyProvider); ok {
+		provider.identityFiles = current.identityFiles
+	}
+
+	wrapped, err := provider.WrapDataKey(c.encryption.dataKey)
+	if err != nil {
+		return fmt.Errorf("%w; Failed to encrypt Data Key for age recipients", err)
+	}
+
+	db := c.db
+	db.DataKey = base64.StdEncoding.EncodeToString(wrapped)
+	db.AgeRecipients = recipients
+
+	if err := c.persist(&db, nil); err != nil {
+		return err
+	}
+
+	c.db = db
+	c.provider = provider
+	c.encryption = &Encryption{
+		dataKey:  c.encryption.dataKey,
+		provider: provider,
+	}
+
+	return nil
+}
+
+// DataKeyWrappings returns every copy of the Data Key held in the DB, starting with the main one
+func (c *ConfigstoreClient) DataKeyWrappings() []DataKeyWrapping {
+	return c.db.dataKeyWrappings()
+}
+
+// AddKMSDataKey adds a copy of the Data Key wrapped by a KMS Master Key, which may be in a different
+// Region (or require a different IAM Role) from the main one. The copy uses the same encryption context
+// and AWS settings as the main copy of the Data Key.
+func (c *ConfigstoreClient) AddKMSDataKey(masterKey string, region string, role string) error {
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if masterKey == "" {
+		return errors.New("you have to specify a non-empty Master Key to wrap the Data Key with")
+	}
+
+	w := DataKeyWrapping{
+		Region:            region,
+		Role:              role,
+		MasterKeyId:       masterKey,
+		EncryptionContext: c.db.EncryptionContext,
+		AWS:               c.db.AWS,
+	}
+
+	provider, err := c.createKMSDataKeyProvider(&w)
+	if err != nil {
+		return err
+	}
+
+	return c.AddDataKey(provider, w)
+}
+
+// AddPassphraseDataKey adds a copy of the Data Key wrapped by a key derived from the given passphrase
+func (c *ConfigstoreClient) AddPassphraseDataKey(passphrase []byte) error {
+	params, err := newPassphraseParams()
+	if err != nil {
+		return err
+	}
+
+	provider, err := createPassphraseKeyProvider(*params, passphrase)
+	if err != nil {
+		return err
+	}
+
+	return c.AddDataKey(provider, DataKeyWrapping{
+		Passphrase: params,
+	})
+}
+
+// AddAgeDataKey adds a copy of the Data Key encrypted for the given age recipients
+func (c *ConfigstoreClient) AddAgeDataKey(recipients []string) error {
+	provider, err := createAgeKeyProvider(recipients, nil)
+	if err != nil {
+		return err
+	}
+
+	return c.AddDataKey(provider, DataKeyWrapping{
+		AgeRecipients: recipients,
+	})
+}
+
+// AddDataKey wraps the Data Key with the given KeyProvider, and stores it as an additional copy. The
+// settings the provider needs for unwrapping it later should be set on the DataKeyWrapping passed in.
+func (c *ConfigstoreClient) AddDataKey(provider KeyProvider, w DataKeyWrapping) error {
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if provider.Name() == InsecureKeyProviderName {
+		return errors.New("cannot add a plain text copy of the Data Key")
+	}
+
+	if err := c.initEncryption(); err != nil {
+		return err
+	}
+
+	wrapped, err := provider.WrapDataKey(c.encryption.dataKey)
+	if err != nil {
+		return fmt.Errorf("%w; Failed to wrap Data Key", err)
+	}
+
+	w.KeyProvider = provider.Name()
+	w.DataKey = base64.StdEncoding.EncodeToString(wrapped)
+
+	db := c.db
+	db.AdditionalDataKeys = append(append([]DataKeyWrapping{}, c.db.AdditionalDataKeys...), w)
+
+	if err := c.persist(&db, nil); err != nil {
+		return err
+	}
+
+	c.db = db
+	return nil
+}
+
+// RemoveDataKey removes the copy of the Data Key at the given index (as returned by DataKeyWrappings).
+// When removing the main copy, the first additional copy takes its place. The last remaining copy cannot be removed.
+// Note that anyone who had access via the removed copy may still hold the Data Key - use RotateDataKey if that's a concern.
+func (c *ConfigstoreClient) RemoveDataKey(index int) error {
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	wrappings := c.db.dataKeyWrappings()
+
+	if index < 0 || index >= len(wrappings) {
+		return fmt.Errorf("no copy of the Data Key at index: %d", index)
+	}
+
+	if len(wrappings) == 1 {
+		return errors.New("cannot remove the last copy of the Data Key")
+	}
+
+	remaining := append(append([]DataKeyWrapping{}, wrappings[:index]...), wrappings[index+1:]...)
+
+	db := c.db
+	db.setPrimaryDataKey(remaining[0])
+	db.AdditionalDataKeys = remaining[1:]
+
+	if len(db.AdditionalDataKeys) == 0 {
+		db.AdditionalDataKeys = nil
+	}
+
+	if err := c.persist(&db, nil); err != nil {
+		return err
+	}
+
+	c.db = db
+
+	if index == 0 {
+		c.provider = nil
+	}
+
 	return nil
 }
 
@@ -431,8 +1268,15 @@ func (c *ConfigstoreClient) TestTemplateString(t string) (bool, error) {
 ///////////////////////////////////////////////////////////////////////////////////////////////////
 // Factory
 
-func NewConfigstoreClient(dbFile string, overrideFiles []string, ignoreRole bool) (*ConfigstoreClient, error) {
-	db, err := loadDB(dbFile)
+// NewConfigstoreClient loads the Configstore DB from dbFile, which is either a local path, or the location of
+// a DB in a storage backend (like s3://bucket/key; see storage.go)
+func NewConfigstoreClient(dbFile string, overrideFiles []string, ignoreRole bool, opts ...ClientOption) (*ConfigstoreClient, error) {
+	storage, err := OpenStorage(dbFile)
+	if err != nil {
+		return nil, err
+	}
+
+	db, version, err := readDB(storage)
 	if err != nil {
 		return nil, err
 	}
@@ -445,27 +1289,31 @@ func NewConfigstoreClient(dbFile string, overrideFiles []string, ignoreRole bool
 			return nil, err
 		}
 
-		for k := range overrides {
-			mainVal, exists := db.Data[k]
-			if !exists {
-				return nil, errors.New("override key doesn't exist in Configstore DB: " + k)
-			}
-
-			if mainVal.IsSecret {
-				return nil, errors.New("trying to override key with secret value: " + k)
+		for k, v := range overrides {
+			if err := validateOverride(db, k, v); err != nil {
+				return nil, err
 			}
 		}
 	}
 
 	var c = &ConfigstoreClient{
-		dbFile:     dbFile,
+		storage:    storage,
+		dbVersion:  version,
 		db:         db,
 		encryption: nil,
-		ignoreRole: ignoreRole,
-		overrides:  overrides,
+		provider:   nil,
+		providerConfig: ProviderConfig{
+			IgnoreRole: ignoreRole,
+		},
+		agent:     agentFromEnv(),
+		overrides: overrides,
+	}
+
+	for _, opt := range opts {
+		opt(c)
 	}
 
-	if err := c.ensureLatestVersion(); err != nil {
+	if err := c.load(db); err != nil {
 		return nil, err
 	}
 
@@ -473,59 +1321,160 @@ func NewConfigstoreClient(dbFile string, overrideFiles []string, ignoreRole bool
 }
 
 func InitConfigstore(dir string, region string, role string, masterKey string, isInsecure bool) (*ConfigstoreClient, error) {
-	var dataKey string
+	if !isInsecure {
+		return InitKMSConfigstore(dir, region, role, masterKey, nil, nil)
+	}
+
+	fmt.Printf("Initialising **Insecure** Configstore into directory: %s\n", dir)
+
+	db := ConfigstoreDB{
+		Version:    latestVersion,
+		IsInsecure: true,
+		Data:       make(map[string]ConfigstoreDBValue),
+	}
+
+	return initConfigstore(dir, db, insecureKeyProvider{})
+}
 
-	if !isInsecure && masterKey == "" {
+// InitKMSConfigstore creates a new Configstore in the given directory, with its Data Key generated and
+// wrapped by the given KMS Master Key. The encryption context is optional; when set, it's stored in the DB
+// and passed along with every KMS call, so it can be used in KMS key policies, and shows up in CloudTrail.
+// The AWS settings are optional as well, and are stored in the DB for use whenever KMS is called. Of the client
+// options, the ones for KMS (like WithMFATokenFunc and WithAWSOverrides) are used for generating the Data Key.
+func InitKMSConfigstore(dir string, region string, role string, masterKey string, encryptionContext map[string]string, settings *AWSSettings, opts ...ClientOption) (*ConfigstoreClient, error) {
+	if masterKey == "" {
 		return nil, errors.New("you have to specify --master-key if --insecure is not set")
 	}
 
-	if isInsecure {
-		fmt.Printf("Initialising **Insecure** Configstore into directory: %s\n", dir)
-		// Since we're storing it as plain text, it doesn't really matter anyway
-		dataKey = "OfvuQJ0Cis1CvnFV2KTTYv3WCPKXOIord3OBDc0kwcU="
-		region = ""
-		role = ""
+	if role != "" {
+		fmt.Printf("Initialising Configstore for Region \"%s\" with Master Key \"%s\", using IAM Role \"%s\", into directory: %s\n", region, masterKey, role, dir)
 	} else {
-		if role != "" {
-			fmt.Printf("Initialising Configstore for Region \"%s\" with Master Key \"%s\", using IAM Role \"%s\", into directory: %s\n", region, masterKey, role, dir)
-		} else {
-			fmt.Printf("Initialising Configstore for Region \"%s\" with Master Key \"%s\" into directory: %s\n", region, masterKey, dir)
-		}
+		fmt.Printf("Initialising Configstore for Region \"%s\" with Master Key \"%s\" into directory: %s\n", region, masterKey, dir)
+	}
 
-		aws, err := createAWSSession(region, role)
-		if err != nil {
-			return nil, err
-		}
+	db := ConfigstoreDB{
+		Version:           latestVersion,
+		Region:            region,
+		MasterKeyId:       masterKey,
+		EncryptionContext: encryptionContext,
+		AWS:               settings,
+		Role:              role,
+		Data:              make(map[string]ConfigstoreDBValue),
+	}
 
-		kms, _ := aws.createKMS()
+	w := db.primaryDataKey()
 
-		generated, err := kms.generateDataKey(masterKey)
-		if err != nil {
-			return nil, err
-		}
-		dataKey = base64.StdEncoding.EncodeToString(generated.CiphertextBlob)
+	var options ConfigstoreClient
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	provider, err := options.createKMSDataKeyProvider(&w)
+	if err != nil {
+		return nil, err
 	}
 
+	c, err := initConfigstore(dir, db, provider)
+	if err != nil {
+		return nil, err
+	}
+
+	c.providerConfig.AWS = options.providerConfig.AWS
+	c.providerConfig.MFATokenFunc = options.providerConfig.MFATokenFunc
+
+	return c, nil
+}
+
+// InitPassphraseConfigstore creates a new Configstore in the given directory, with its Data Key
+// wrapped by a key derived from the given passphrase
+func InitPassphraseConfigstore(dir string, passphrase []byte) (*ConfigstoreClient, error) {
+	fmt.Printf("Initialising passphrase-protected Configstore into directory: %s\n", dir)
+
+	params, err := newPassphraseParams()
+	if err != nil {
+		return nil, err
+	}
+
+	provider, err := createPassphraseKeyProvider(*params, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	db := ConfigstoreDB{
+		Version:    latestVersion,
+		Passphrase: params,
+		Data:       make(map[string]ConfigstoreDBValue),
+	}
+
+	return initConfigstore(dir, db, provider)
+}
+
+// InitAgeConfigstore creates a new Configstore in the given directory, with its Data Key encrypted
+// for the given age (X25519) recipients
+func InitAgeConfigstore(dir string, recipients []string) (*ConfigstoreClient, error) {
+	fmt.Printf("Initialising age-backed Configstore for %d recipient(s) into directory: %s\n", len(recipients), dir)
+
+	provider, err := createAgeKeyProvider(recipients, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	db := ConfigstoreDB{
+		Version:       latestVersion,
+		AgeRecipients: recipients,
+		Data:          make(map[string]ConfigstoreDBValue),
+	}
+
+	return initConfigstore(dir, db, provider)
+}
+
+// InitConfigstoreWithProvider creates a new Configstore in the given directory, with its Data Key
+// protected by a custom KeyProvider. The provider has to be registered via RegisterKeyProvider (or passed
+// in via WithKeyProvider) in order for the Configstore to be opened again later.
+func InitConfigstoreWithProvider(dir string, provider KeyProvider) (*ConfigstoreClient, error) {
 	db := ConfigstoreDB{
-		Version:     2,
-		Region:      region,
-		DataKey:     dataKey,
-		MasterKeyId: masterKey,
-		IsInsecure:  isInsecure,
-		Role:        role,
-		Data:        make(map[string]ConfigstoreDBValue),
+		Version: latestVersion,
+		Data:    make(map[string]ConfigstoreDBValue),
+	}
+
+	return initConfigstore(dir, db, provider)
+}
+
+func initConfigstore(dir string, db ConfigstoreDB, provider KeyProvider) (*ConfigstoreClient, error) {
+	dataKey, wrapped, err := generateDataKey(provider)
+	if err != nil {
+		return nil, err
 	}
 
-	dbFile := dir + "/configstore.json"
-	err := saveDB(dbFile, db)
+	id, err := generateDBId()
+	if err != nil {
+		return nil, err
+	}
+
+	db.Id = id
+	db.KeyProvider = provider.Name()
+	db.DataKey = base64.StdEncoding.EncodeToString(wrapped)
+
+	storage, err := OpenStorage(dir + "/configstore.json")
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := writeDB(storage, db, "")
 	if err != nil {
 		return nil, err
 	}
 
 	return &ConfigstoreClient{
-		dbFile:     dbFile,
-		db:         db,
-		encryption: nil,
-		ignoreRole: false, // There's no reason we'd want to do this right after initialisation
+		storage:   storage,
+		dbVersion: version,
+		db:        db,
+		encryption: &Encryption{
+			dataKey:  dataKey,
+			provider: provider,
+		},
+		provider:       provider,
+		providerConfig: ProviderConfig{}, // There's no reason we'd want to ignore the role right after initialisation
+		overrides:      make(map[string]string),
 	}, nil
 }
@@ -0,0 +1,365 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Configstore DBs can be stored as JSON, YAML or TOML; the format is picked based on the extension of the DB file,
+// defaulting to JSON. All formats go through the JSON encoding of the DB, so they share the same field names, and
+// the integrity tag (which is computed over the JSON encoding) stays valid when a DB is converted.
+//
+// When a YAML DB is saved, the order of the keys and any comments in the existing file are kept. TOML DBs are
+// always written with their keys sorted, and without comments.
+
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+	FormatTOML = "toml"
+)
+
+type dbCodec interface {
+	decode(data []byte, v interface{}) error
+	// encode takes the current contents of the DB file (if any), so that their layout can be kept
+	encode(db ConfigstoreDB, existing []byte) ([]byte, error)
+}
+
+var codecs = map[string]dbCodec{
+	FormatJSON: jsonCodec{},
+	FormatYAML: yamlCodec{},
+	FormatTOML: tomlCodec{},
+}
+
+var formatExtensions = map[string]string{
+	".json": FormatJSON,
+	".yaml": FormatYAML,
+	".yml":  FormatYAML,
+	".toml": FormatTOML,
+}
+
+// The file names a Configstore DB is looked for under in a directory, in order of preference
+var dbFileNames = []string{"configstore.json", "configstore.yaml", "configstore.yml", "configstore.toml"}
+
+// FormatForFile returns the storage format used for the given DB file, based on its extension
+func FormatForFile(path string) string {
+	if format, exists := formatExtensions[strings.ToLower(filepath.Ext(path))]; exists {
+		return format
+	}
+
+	return FormatJSON
+}
+
+// codecForFile returns the codec for the storage format of the given DB file
+func codecForFile(path string) dbCodec {
+	return codecs[FormatForFile(path)]
+}
+
+// FindDBFile returns the path of the Configstore DB in the given directory, in whichever format it's stored. If
+// there's no DB in the directory, the path for a JSON one is returned.
+func FindDBFile(dir string) string {
+	for _, name := range dbFileNames {
+		if _, err := os.Stat(dir + "/" + name); err == nil {
+			return dir + "/" + name
+		}
+	}
+
+	return dir + "/" + dbFileNames[0]
+}
+
+// ConvertDB copies the Configstore DB at src to dst (either of which can be a local path, or the location of
+// a DB in a storage backend; see storage.go), in the format matching the extension of dst. The DB is copied as
+// is, without being upgraded or decrypted.
+func ConvertDB(src string, dst string) error {
+	if filepath.Clean(src) == filepath.Clean(dst) {
+		return errors.New("cannot convert Configstore DB into the same file: " + src)
+	}
+
+	srcStorage, err := OpenStorage(src)
+	if err != nil {
+		return err
+	}
+
+	dstStorage, err := OpenStorage(dst)
+	if err != nil {
+		return err
+	}
+
+	if existing, err := readIfExists(dstStorage); err != nil {
+		return err
+	} else if existing != nil {
+		return errors.New("destination file already exists: " + dst)
+	}
+
+	// Keep the DB from being changed while it's being converted
+	unlock, err := srcStorage.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	db, _, err := readDB(srcStorage)
+	if err != nil {
+		return err
+	}
+
+	_, err = writeDB(dstStorage, db, "")
+	return err
+}
+
+///////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////
+// JSON
+
+type jsonCodec struct{}
+
+func (jsonCodec) decode(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) encode(db ConfigstoreDB, _ []byte) ([]byte, error) {
+	return json.MarshalIndent(db, "", "  ")
+}
+
+///////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////
+// YAML
+
+type yamlCodec struct{}
+
+func (yamlCodec) decode(data []byte, v interface{}) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+
+	if len(doc.Content) == 0 {
+		return errors.New("empty YAML document")
+	}
+
+	value, err := yamlValue(doc.Content[0])
+	if err != nil {
+		return err
+	}
+
+	jsonStr, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(jsonStr, v)
+}
+
+// yamlValue turns a YAML node into the equivalent value for encoding as JSON. Mapping keys are always taken as
+// strings, even if they look like numbers or booleans.
+func yamlValue(n *yaml.Node) (interface{}, error) {
+	switch n.Kind {
+	case yaml.MappingNode:
+		m := make(map[string]interface{}, len(n.Content)/2)
+
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			v, err := yamlValue(n.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+
+			m[n.Content[i].Value] = v
+		}
+
+		return m, nil
+	case yaml.SequenceNode:
+		s := make([]interface{}, len(n.Content))
+
+		for i, item := range n.Content {
+			v, err := yamlValue(item)
+			if err != nil {
+				return nil, err
+			}
+
+			s[i] = v
+		}
+
+		return s, nil
+	case yaml.AliasNode:
+		return yamlValue(n.Alias)
+	default:
+		var v interface{}
+		if err := n.Decode(&v); err != nil {
+			return nil, err
+		}
+
+		return v, nil
+	}
+}
+
+func (yamlCodec) encode(db ConfigstoreDB, existing []byte) ([]byte, error) {
+	jsonStr, err := json.Marshal(db)
+	if err != nil {
+		return nil, err
+	}
+
+	// JSON is valid YAML, so this gives us a node tree with the fields in the order of the JSON encoding
+	var doc yaml.Node
+	if err := yaml.Unmarshal(jsonStr, &doc); err != nil {
+		return nil, err
+	}
+
+	resetYAMLStyle(&doc)
+
+	if len(existing) > 0 {
+		var old yaml.Node
+		if err := yaml.Unmarshal(existing, &old); err == nil {
+			keepYAMLLayout(&doc, &old)
+		}
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// resetYAMLStyle switches every node parsed from JSON over to the default (block) style
+func resetYAMLStyle(n *yaml.Node) {
+	n.Style = 0
+
+	for _, c := range n.Content {
+		resetYAMLStyle(c)
+	}
+}
+
+// keepYAMLLayout copies the comments from the old version of a YAML document over to the new one, and orders the
+// keys of each mapping the way they were ordered before. Keys which are new are added after the existing ones.
+func keepYAMLLayout(n *yaml.Node, old *yaml.Node) {
+	if n.HeadComment == "" && n.LineComment == "" && n.FootComment == "" {
+		n.HeadComment, n.LineComment, n.FootComment = old.HeadComment, old.LineComment, old.FootComment
+	}
+
+	if n.Kind != old.Kind {
+		return
+	}
+
+	switch n.Kind {
+	case yaml.DocumentNode:
+		if len(n.Content) > 0 && len(old.Content) > 0 {
+			keepYAMLLayout(n.Content[0], old.Content[0])
+		}
+	case yaml.MappingNode:
+		pairs := make(map[string][]*yaml.Node, len(n.Content)/2)
+		order := make([]string, 0, len(n.Content)/2)
+
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			pairs[n.Content[i].Value] = n.Content[i : i+2]
+			order = append(order, n.Content[i].Value)
+		}
+
+		content := make([]*yaml.Node, 0, len(n.Content))
+
+		for i := 0; i+1 < len(old.Content); i += 2 {
+			key := old.Content[i].Value
+
+			pair, exists := pairs[key]
+			if !exists {
+				continue
+			}
+
+			keepYAMLLayout(pair[0], old.Content[i])
+			keepYAMLLayout(pair[1], old.Content[i+1])
+
+			content = append(content, pair...)
+			delete(pairs, key)
+		}
+
+		for _, key := range order {
+			if pair, exists := pairs[key]; exists {
+				content = append(content, pair...)
+			}
+		}
+
+		n.Content = content
+	}
+}
+
+///////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////
+// TOML
+
+type tomlCodec struct{}
+
+func (tomlCodec) decode(data []byte, v interface{}) error {
+	var m map[string]interface{}
+	if _, err := toml.Decode(string(data), &m); err != nil {
+		return err
+	}
+
+	jsonStr, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(jsonStr, v)
+}
+
+func (tomlCodec) encode(db ConfigstoreDB, _ []byte) ([]byte, error) {
+	jsonStr, err := json.Marshal(db)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(jsonStr))
+	dec.UseNumber()
+
+	var m map[string]interface{}
+	if err := dec.Decode(&m); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	enc := toml.NewEncoder(&buf)
+	enc.Indent = ""
+
+	if err := enc.Encode(tomlValue(m)); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// tomlValue converts the numbers in a value decoded from JSON into integers (or floats), since TOML has separate
+// types for them
+func tomlValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, item := range t {
+			t[k] = tomlValue(item)
+		}
+	case []interface{}:
+		for i, item := range t {
+			t[i] = tomlValue(item)
+		}
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i
+		}
+
+		if f, err := t.Float64(); err == nil {
+			return f
+		}
+	}
+
+	return v
+}
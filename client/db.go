@@ -1,30 +1,175 @@
 package client
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
 )
 
 ///////////////////////////////////////////////////////////////////////////////
 ///////////////////////////////////////////////////////////////////////////////
 // Types
 
+// The version of the Configstore DB format written by this version of the client; older
+// DBs are upgraded to this in memory when loaded (see migrations.go)
+const latestVersion = 5
+
+
 type ConfigstoreDB struct {
-	Version     int                           `json:"version"`
-	Region      string                        `json:"region"`
-	Role        string                        `json:"role"`
-	IsInsecure  bool                          `json:"is_insecure"`
-	DataKey     string                        `json:"data_key"`
-	MasterKeyId string                        `json:"master_key_id,omitempty"`
-	Data        map[string]ConfigstoreDBValue `json:"data"`
+	Version            int                           `json:"version"`
+	Id                 string                        `json:"id,omitempty"`
+	Region             string                        `json:"region"`
+	Role               string                        `json:"role"`
+	IsInsecure         bool                          `json:"is_insecure"`
+	KeyProvider        string                        `json:"key_provider,omitempty"`
+	DataKey            string                        `json:"data_key"`
+	MasterKeyId        string                        `json:"master_key_id,omitempty"`
+	EncryptionContext  map[string]string             `json:"encryption_context,omitempty"`
+	AWS                *AWSSettings                  `json:"aws,omitempty"`
+	Passphrase         *PassphraseParams             `json:"passphrase,omitempty"`
+	AgeRecipients      []string                      `json:"age_recipients,omitempty"`
+	AdditionalDataKeys []DataKeyWrapping             `json:"additional_data_keys,omitempty"`
+	Data               map[string]ConfigstoreDBValue `json:"data"`
+	Integrity          string                        `json:"integrity,omitempty"`
+}
+
+// DataKeyWrapping describes one wrapped copy of the Data Key: which KeyProvider wrapped it, and the
+// settings needed by that provider to unwrap it again. The main copy is stored directly on the ConfigstoreDB,
+// while any additional copies (for a KMS key in a different Region, or a break-glass passphrase for example)
+// are stored under AdditionalDataKeys.
+type DataKeyWrapping struct {
+	KeyProvider       string            `json:"key_provider"`
+	DataKey           string            `json:"data_key"`
+	Region            string            `json:"region,omitempty"`
+	Role              string            `json:"role,omitempty"`
+	MasterKeyId       string            `json:"master_key_id,omitempty"`
+	EncryptionContext map[string]string `json:"encryption_context,omitempty"`
+	AWS               *AWSSettings      `json:"aws,omitempty"`
+	Passphrase        *PassphraseParams `json:"passphrase,omitempty"`
+	AgeRecipients     []string          `json:"age_recipients,omitempty"`
 }
 
 type ConfigstoreDBValue struct {
 	Value    string `json:"value"`
 	IsBinary bool   `json:"is_binary"`
 	IsSecret bool   `json:"is_secret"`
+	Type     string `json:"type,omitempty"` // Optional; see ValueTypes
+	KeyMetadata
+	History []HistoryEntry `json:"history,omitempty"` // Previous values, most recent first
+}
+
+// KeyMetadata describes what a key is for, and who is responsible for it. The timestamps are maintained
+// automatically whenever a value is set, while the rest can be edited via SetMetadata.
+type KeyMetadata struct {
+	Description string     `json:"description,omitempty"`
+	Owner       string     `json:"owner,omitempty"`
+	Tags        []string   `json:"tags,omitempty"`
+	CreatedAt   *time.Time `json:"created_at,omitempty"`
+	UpdatedAt   *time.Time `json:"updated_at,omitempty"`
+}
+
+// HasTags checks whether every one of the given tags is set
+func (m KeyMetadata) HasTags(tags []string) bool {
+	for _, t := range tags {
+		found := false
+
+		for _, mt := range m.Tags {
+			if mt == t {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// normaliseTags trims, de-duplicates and sorts the given tags, dropping empty ones
+func normaliseTags(tags []string) []string {
+	seen := make(map[string]bool)
+	normalised := make([]string, 0)
+
+	for _, t := range tags {
+		t = strings.TrimSpace(t)
+		if t == "" || seen[t] {
+			continue
+		}
+
+		seen[t] = true
+		normalised = append(normalised, t)
+	}
+
+	if len(normalised) == 0 {
+		return nil
+	}
+
+	sort.Strings(normalised)
+	return normalised
+}
+
+// primaryDataKey returns the main copy of the Data Key
+func (c ConfigstoreDB) primaryDataKey() DataKeyWrapping {
+	return DataKeyWrapping{
+		KeyProvider:       keyProviderName(&c),
+		DataKey:           c.DataKey,
+		Region:            c.Region,
+		Role:              c.Role,
+		MasterKeyId:       c.MasterKeyId,
+		EncryptionContext: c.EncryptionContext,
+		AWS:               c.AWS,
+		Passphrase:        c.Passphrase,
+		AgeRecipients:     c.AgeRecipients,
+	}
+}
+
+// setPrimaryDataKey replaces the main copy of the Data Key
+func (c *ConfigstoreDB) setPrimaryDataKey(w DataKeyWrapping) {
+	c.KeyProvider = w.KeyProvider
+	c.IsInsecure = w.KeyProvider == InsecureKeyProviderName
+	c.DataKey = w.DataKey
+	c.Region = w.Region
+	c.Role = w.Role
+	c.MasterKeyId = w.MasterKeyId
+	c.EncryptionContext = w.EncryptionContext
+	c.AWS = w.AWS
+	c.Passphrase = w.Passphrase
+	c.AgeRecipients = w.AgeRecipients
+}
+
+// secretAAD returns the associated data secrets stored under the given key are bound to, which
+// ties each ciphertext to both the key name, and the DB it was created in
+func (c ConfigstoreDB) secretAAD(key string) []byte {
+	return []byte(c.Id + "/" + key)
+}
+
+// minCiphertextVersion returns the oldest format secrets may be stored in: the migrations to version 4 and 5
+// upgrade every secret in the DB (see migrations.go). The version isn't authenticated (unless integrity is
+// required) though, so a DB with an identity is always held to the latest format: the identity only exists
+// from version 5, and removing it breaks every secret bound to it.
+func (c ConfigstoreDB) minCiphertextVersion() int {
+	switch {
+	case c.Version >= 5 || c.Id != "":
+		return ciphertextV3
+	case c.Version == 4:
+		return ciphertextV2
+	default:
+		return ciphertextV1
+	}
+}
+
+// dataKeyWrappings returns all copies of the Data Key, in the order in which they should be tried
+func (c ConfigstoreDB) dataKeyWrappings() []DataKeyWrapping {
+	return append([]DataKeyWrapping{c.primaryDataKey()}, c.AdditionalDataKeys...)
 }
 
 func (c ConfigstoreDB) validate() (ConfigstoreDB, error) {
@@ -47,45 +192,86 @@ func (c ConfigstoreDB) validate() (ConfigstoreDB, error) {
 ///////////////////////////////////////////////////////////////////////////////
 // Helpers
 
-// loadDB looks for a file at path dbFile, loads the JSON string
-// from it, and parses it into a ConfigStoreDB. An error is returned if
-// the file is missing, is not valid JSON, or the parsed contents
-// are missing required fields.
+// loadDB loads the Configstore DB from the given location (a local file, or any
+// other Storage; see storage.go).
 func loadDB(dbFile string) (ConfigstoreDB, error) {
+	s, err := OpenStorage(dbFile)
+	if err != nil {
+		return ConfigstoreDB{}, err
+	}
+
+	db, _, err := readDB(s)
+	return db, err
+}
+
+// readDB reads the JSON (or YAML, or TOML; see codec.go) string from the given
+// Storage, and parses it into a ConfigStoreDB, which is returned along with its
+// version. An error is returned if the DB is missing, cannot be parsed, or the
+// parsed contents are missing required fields.
+func readDB(s Storage) (ConfigstoreDB, string, error) {
 	var db ConfigstoreDB
 
-	if dbFile == "" {
-		return ConfigstoreDB{}, errors.New("cannot load Configstore DB from empty path")
-	}
+	dbFile := s.Location()
 
-	jsonStr, err := ioutil.ReadFile(dbFile)
+	jsonStr, version, err := s.Read()
 	if err != nil {
-		return ConfigstoreDB{}, fmt.Errorf("%w; Failed to load DB file: %s", err, dbFile)
+		return ConfigstoreDB{}, "", fmt.Errorf("%w; Failed to load DB file: %s", err, dbFile)
 	}
 
-	if err := json.Unmarshal(jsonStr, &db); err != nil {
+	if err := codecForFile(dbFile).decode(jsonStr, &db); err != nil {
 		switch t := err.(type) {
 		case *json.SyntaxError:
-			return ConfigstoreDB{}, fmt.Errorf("%w; Failed to unmarshal json from DB file \"%s\", error at position %d (\"%s\")", err, dbFile, t.Offset, SafeSlice(string(jsonStr), int(t.Offset - 10), int(t.Offset + 10)))
+			return ConfigstoreDB{}, "", fmt.Errorf("%w; Failed to unmarshal json from DB file \"%s\", error at position %d (\"%s\")", err, dbFile, t.Offset, SafeSlice(string(jsonStr), int(t.Offset - 10), int(t.Offset + 10)))
 		default:
-			return ConfigstoreDB{}, fmt.Errorf("%w; Failed to unmarshal json from DB file \"%s\"", err, dbFile)
+			return ConfigstoreDB{}, "", fmt.Errorf("%w; Failed to unmarshal %s from DB file \"%s\"", err, FormatForFile(dbFile), dbFile)
 		}
 	}
 
-	return db.validate()
+	db, err = db.validate()
+	return db, version, err
 }
 
-// saveDB takes the provided ConfigstoreDB, marshals it into pretty-printed
-// JSON, and then writes said JSON string into a file specified by dbFile
+// generateDBId creates a random identifier for a new Configstore DB
+func generateDBId() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("%w; Failed to generate DB identifier", err)
+	}
+
+	return hex.EncodeToString(id), nil
+}
+
+// saveDB writes the provided ConfigstoreDB to the given location (a local file,
+// or any other Storage; see storage.go), replacing whatever is there.
 func saveDB(dbFile string, db ConfigstoreDB) error {
-	jsonStr, err := json.MarshalIndent(db, "", "  ")
+	s, err := OpenStorage(dbFile)
 	if err != nil {
-		return errors.New("failed to marshal Configstore DB into JSON")
+		return err
 	}
 
-	if err := ioutil.WriteFile(dbFile, jsonStr, 0644); err != nil {
-		return err
+	_, version, err := s.Read()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w; Failed to read DB: %s", err, dbFile)
+	}
+
+	_, err = writeDB(s, db, version)
+	return err
+}
+
+// writeDB takes the provided ConfigstoreDB, marshals it into pretty-printed JSON
+// (or YAML, or TOML; see codec.go), and then writes it into the given Storage,
+// as long as the DB stored there is still at the given version. The version of
+// the new contents is returned.
+func writeDB(s Storage, db ConfigstoreDB, version string) (string, error) {
+	existing, err := readIfExists(s)
+	if err != nil {
+		return "", err
+	}
+
+	jsonStr, err := codecForFile(s.Location()).encode(db, existing)
+	if err != nil {
+		return "", fmt.Errorf("%w; Failed to marshal Configstore DB into %s", err, FormatForFile(s.Location()))
 	}
 
-	return nil
+	return s.Write(jsonStr, version)
 }
@@ -8,87 +8,157 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"strings"
+)
+
+// Secrets are stored with a version prefix, which tells us which scheme was used to encrypt them:
+//   - v1 (no prefix): AES-CFB over the base64 encoded value, with no authentication. Only supported for reading.
+//   - v2: AES-256-GCM, with the random nonce prepended to the sealed value. Only supported for reading.
+//   - v3: same as v2, but with the name of the key (and the identity of the DB) bound in as associated data,
+//     so that a ciphertext moved to a different key fails to decrypt
+const (
+	ciphertextV1 = 1
+	ciphertextV2 = 2
+	ciphertextV3 = 3
+
+	ciphertextV2Prefix = "v2:"
+	ciphertextV3Prefix = "v3:"
 )
 
 type Encryption struct {
-	dataKey     []byte
-	masterKeyId string
-	kms         *KMS
+	dataKey  []byte
+	provider KeyProvider
 }
 
-// Used to create an Encryption object for encrypting/decrypting secrets. Will initialise
-// an AWS API Session, and create a KMS instance if one is not passed in.
-// If an IAM Role was defined when the Configstore was created, the `ignoreRole` flag can
-// be used to ignore (not assume) that IAM Role, and instead use the default credentials - this
-// is useful for example on EC2 servers, which cannot assume regular IAM roles, and have to rely
-// on Instance Roles instead (you do however have to make sure that the Instance Role has access
-// to the KMS Key used for the Configstore)
-func createEncryption(db *ConfigstoreDB, kms *KMS, ignoreRole bool) (*Encryption, error) {
-	ciphertext, err := base64.StdEncoding.DecodeString(db.DataKey)
+// Used to create an Encryption object for encrypting/decrypting secrets, by unwrapping the
+// given copy of the Data Key via the KeyProvider.
+func createEncryption(w *DataKeyWrapping, provider KeyProvider) (*Encryption, error) {
+	wrapped, err := base64.StdEncoding.DecodeString(w.DataKey)
 	if err != nil {
 		return nil, fmt.Errorf("%w; Failed to load ciphertext", err)
 	}
 
-	var dataKey []byte
-	var masterKey = ""
+	dataKey, err := provider.UnwrapDataKey(wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("%w; Failed to decrypt Data Key", err)
+	}
 
-	if db.IsInsecure {
-		// The dataKey is stored as plain text
-		dataKey = ciphertext
-	} else {
-		if kms == nil {
-			role := db.Role
+	return &Encryption{
+		dataKey:  dataKey,
+		provider: provider,
+	}, nil
+}
 
-			if ignoreRole == true {
-				role = ""
-			}
+// ciphertextVersion works out which format an encrypted value was stored in. Since the
+// base64 alphabet doesn't contain ":", values without a prefix can only be v1 values.
+func ciphertextVersion(encoded string) (int, error) {
+	idx := strings.Index(encoded, ":")
+	if idx == -1 {
+		return ciphertextV1, nil
+	}
 
-			aws, err := createAWSSession(db.Region, role)
-			if err != nil {
-				return nil, fmt.Errorf("%w; Failed to initialise AWS Session", err)
-			}
+	switch encoded[:idx+1] {
+	case ciphertextV2Prefix:
+		return ciphertextV2, nil
+	case ciphertextV3Prefix:
+		return ciphertextV3, nil
+	default:
+		return 0, fmt.Errorf("unsupported ciphertext format: %s", encoded[:idx])
+	}
+}
 
-			kms, err = aws.createKMS()
-			if err != nil {
-				return nil, fmt.Errorf("%w; Failed to initialise KMS", err)
-			}
-		}
+func (e Encryption) gcm() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(e.dataKey)
+	if err != nil {
+		return nil, err
+	}
 
-		dataKey, masterKey, err = kms.decrypt(ciphertext)
-		if err != nil {
-			return nil, fmt.Errorf("%w; Failed to decrypt Data Key", err)
-		}
+	return cipher.NewGCM(block)
+}
+
+// generateLocalDataKey creates a random AES-256 key locally, for KeyProviders which
+// can't generate Data Keys themselves
+func generateLocalDataKey() ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
 	}
 
-	return &Encryption{
-		dataKey:     dataKey,
-		masterKeyId: masterKey,
-		kms:         kms,
-	}, nil
+	return key, nil
 }
 
-func (e Encryption) encrypt(text []byte) (string, error) {
-	block, err := aes.NewCipher(e.dataKey)
+// encrypt seals the given value in the latest (v3) format, authenticating the associated data
+// along with it; the same associated data has to be provided again for decryption.
+func (e Encryption) encrypt(text []byte, aad []byte) (string, error) {
+	gcm, err := e.gcm()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, text, aad)
+
+	return ciphertextV3Prefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decrypt opens a value stored in the given format, or any newer one (see ConfigstoreDB.minCiphertextVersion).
+// Values in older formats are rejected, so that a secret can't be downgraded by pasting in an old (unauthenticated,
+// or unbound) ciphertext. The associated data is ignored for v1 and v2 values, since those were never bound to anything.
+func (e Encryption) decrypt(encoded string, aad []byte, minVersion int) (string, error) {
+	version, err := ciphertextVersion(encoded)
 	if err != nil {
 		return "", err
 	}
 
-	b := base64.StdEncoding.EncodeToString(text)
+	if version < minVersion {
+		if version == ciphertextV2 {
+			return "", errors.New("value is not bound to its key name; it may have been copied in from another key or Configstore")
+		}
+
+		return "", fmt.Errorf("value is stored in a legacy format (v%d), which is no longer accepted in this Configstore DB", version)
+	}
+
+	switch version {
+	case ciphertextV1:
+		return e.decryptV1(encoded)
+	case ciphertextV2:
+		return e.decryptGCM(strings.TrimPrefix(encoded, ciphertextV2Prefix), nil)
+	default:
+		return e.decryptGCM(strings.TrimPrefix(encoded, ciphertextV3Prefix), aad)
+	}
+}
+
+func (e Encryption) decryptGCM(encoded string, aad []byte) (string, error) {
+	text, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
 
-	ciphertext := make([]byte, aes.BlockSize+len(b))
-	iv := ciphertext[:aes.BlockSize]
-	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+	gcm, err := e.gcm()
+	if err != nil {
 		return "", err
 	}
-	cfb := cipher.NewCFBEncrypter(block, iv)
-	cfb.XORKeyStream(ciphertext[aes.BlockSize:], []byte(b))
 
-	encoded := base64.StdEncoding.EncodeToString(ciphertext)
+	if len(text) < gcm.NonceSize() {
+		return "", errors.New("ciphertext too short")
+	}
+
+	nonce := text[:gcm.NonceSize()]
+	data, err := gcm.Open(nil, nonce, text[gcm.NonceSize():], aad)
+	if err != nil {
+		return "", errors.New("ciphertext failed authentication; the value is corrupted, has been tampered with, or was moved from a different key")
+	}
 
-	return encoded, nil
+	return string(data), nil
 }
 
-func (e Encryption) decrypt(encoded string) (string, error) {
+// decryptV1 handles values encrypted with the original AES-CFB scheme. There's no way to
+// tell whether these have been tampered with, so they're upgraded when the DB is migrated.
+func (e Encryption) decryptV1(encoded string) (string, error) {
 	text, err := base64.StdEncoding.DecodeString(encoded)
 	if err != nil {
 		return "", err
@@ -0,0 +1,211 @@
+package client
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// GeneratorOptions customise the value created by a generator. Zero values mean the defaults of the given generator.
+type GeneratorOptions struct {
+	// Number of characters (password), bytes (random), or bits (rsa)
+	Length int
+	// Named set of characters to use (password)
+	Charset string
+	// Output format (random: hex, base64 or base64url; keypairs: pem or openssh)
+	Format string
+}
+
+// GeneratedValue is the result of a generator. Keypair generators also set PublicKey.
+type GeneratedValue struct {
+	Value     []byte
+	PublicKey []byte
+}
+
+// The suffix of the key under which the public part of a generated keypair is stored
+const PublicKeySuffix = "_public"
+
+type generatorFunc func(opts GeneratorOptions) (GeneratedValue, error)
+
+var generators = map[string]generatorFunc{
+	"password": generatePassword,
+	"random":   generateRandom,
+	"uuid":     generateUUID,
+	"rsa":      generateRSA,
+	"ed25519":  generateEd25519,
+}
+
+var charsets = map[string]string{
+	"alpha":   "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	"alnum":   "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789",
+	"numeric": "0123456789",
+	"hex":     "0123456789abcdef",
+	"symbols": "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789!#$%&()*+,-./:;<=>?@[]^_{|}~",
+}
+
+// Generators returns the names of all available generators
+func Generators() []string {
+	names := make([]string, 0, len(generators))
+	for name := range generators {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// GenerateValue creates a new random value with the named generator
+func GenerateValue(generator string, opts GeneratorOptions) (GeneratedValue, error) {
+	g, exists := generators[generator]
+	if !exists {
+		return GeneratedValue{}, fmt.Errorf("unknown generator: %s (available: %s)", generator, strings.Join(Generators(), ", "))
+	}
+
+	if opts.Length < 0 {
+		return GeneratedValue{}, errors.New("length cannot be negative")
+	}
+
+	return g(opts)
+}
+
+func generatePassword(opts GeneratorOptions) (GeneratedValue, error) {
+	length := opts.Length
+	if length == 0 {
+		length = 32
+	}
+
+	name := opts.Charset
+	if name == "" {
+		name = "alnum"
+	}
+
+	charset, exists := charsets[name]
+	if !exists {
+		return GeneratedValue{}, errors.New("unknown charset: " + name)
+	}
+
+	max := big.NewInt(int64(len(charset)))
+	password := make([]byte, length)
+
+	for i := range password {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return GeneratedValue{}, err
+		}
+
+		password[i] = charset[n.Int64()]
+	}
+
+	return GeneratedValue{Value: password}, nil
+}
+
+func generateRandom(opts GeneratorOptions) (GeneratedValue, error) {
+	length := opts.Length
+	if length == 0 {
+		length = 32
+	}
+
+	b := make([]byte, length)
+	if _, err := rand.Read(b); err != nil {
+		return GeneratedValue{}, err
+	}
+
+	var value string
+
+	switch opts.Format {
+	case "", "hex":
+		value = hex.EncodeToString(b)
+	case "base64":
+		value = base64.StdEncoding.EncodeToString(b)
+	case "base64url":
+		value = base64.RawURLEncoding.EncodeToString(b)
+	default:
+		return GeneratedValue{}, errors.New("unsupported format for random generator: " + opts.Format)
+	}
+
+	return GeneratedValue{Value: []byte(value)}, nil
+}
+
+func generateUUID(_ GeneratorOptions) (GeneratedValue, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return GeneratedValue{}, err
+	}
+
+	// Version 4, RFC 4122 variant
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return GeneratedValue{Value: []byte(fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]))}, nil
+}
+
+func generateRSA(opts GeneratorOptions) (GeneratedValue, error) {
+	bits := opts.Length
+	if bits == 0 {
+		bits = 4096
+	}
+
+	if bits < 2048 {
+		return GeneratedValue{}, errors.New("RSA keys have to be at least 2048 bits long")
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return GeneratedValue{}, err
+	}
+
+	return encodeKeypair(key, key.Public(), opts.Format)
+}
+
+func generateEd25519(opts GeneratorOptions) (GeneratedValue, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return GeneratedValue{}, err
+	}
+
+	return encodeKeypair(private, public, opts.Format)
+}
+
+// encodeKeypair encodes the private key as PKCS #8 PEM, and the public key either as PKIX PEM, or in the
+// OpenSSH authorized_keys format
+func encodeKeypair(private interface{}, public interface{}, format string) (GeneratedValue, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return GeneratedValue{}, err
+	}
+
+	value := GeneratedValue{
+		Value: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}),
+	}
+
+	switch format {
+	case "", "pem":
+		der, err := x509.MarshalPKIXPublicKey(public)
+		if err != nil {
+			return GeneratedValue{}, err
+		}
+
+		value.PublicKey = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	case "openssh":
+		key, err := ssh.NewPublicKey(public)
+		if err != nil {
+			return GeneratedValue{}, err
+		}
+
+		value.PublicKey = ssh.MarshalAuthorizedKey(key)
+	default:
+		return GeneratedValue{}, errors.New("unsupported format for keypair: " + format)
+	}
+
+	return value, nil
+}
@@ -17,3 +17,13 @@ func SafeSlice(str string, start int, end int) string {
 
 	return str[s:e]
 }
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,173 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Whenever the value of a key is replaced, the previous one is kept in the history of the key, so that it
+// can be looked up or rolled back to later. Previous values are stored the same way as the current one:
+// if the key holds a secret, its whole history is encrypted (and bound to the key name) as well.
+
+// The number of previous values kept for each key
+const historyLimit = 10
+
+// HistoryEntry is a previous value of a key
+type HistoryEntry struct {
+	Value      string     `json:"value"`
+	IsBinary   bool       `json:"is_binary"`
+	IsSecret   bool       `json:"is_secret"`
+	SetAt      *time.Time `json:"set_at,omitempty"`
+	ReplacedAt time.Time  `json:"replaced_at"`
+}
+
+// pushHistory records the current value of the entry as the most recent previous one, dropping the
+// oldest previous value if the history is full
+func (v ConfigstoreDBValue) pushHistory(replacedAt time.Time) []HistoryEntry {
+	history := append([]HistoryEntry{{
+		Value:      v.Value,
+		IsBinary:   v.IsBinary,
+		IsSecret:   v.IsSecret,
+		SetAt:      v.UpdatedAt,
+		ReplacedAt: replacedAt,
+	}}, v.History...)
+
+	if len(history) > historyLimit {
+		history = history[:historyLimit]
+	}
+
+	return history
+}
+
+// encryptHistory encrypts every plain text value in the history of the given key
+func (c *ConfigstoreClient) encryptHistory(key string, history []HistoryEntry) ([]HistoryEntry, error) {
+	encrypted := make([]HistoryEntry, len(history))
+
+	for i, h := range history {
+		if !h.IsSecret {
+			value, err := c.encryptSecret(key, []byte(h.Value))
+			if err != nil {
+				return nil, err
+			}
+
+			h.Value = value
+			h.IsSecret = true
+		}
+
+		encrypted[i] = h
+	}
+
+	return encrypted, nil
+}
+
+// decryptHistory decrypts every secret value in the history of the given key
+func (c *ConfigstoreClient) decryptHistory(key string, history []HistoryEntry) ([]HistoryEntry, error) {
+	decrypted := make([]HistoryEntry, len(history))
+
+	for i, h := range history {
+		if h.IsSecret {
+			value, err := c.decryptSecret(key, h.Value)
+			if err != nil {
+				return nil, fmt.Errorf("%w; Failed to decrypt previous value %d for key: %s", err, i+1, key)
+			}
+
+			h.Value = value
+			h.IsSecret = false
+		}
+
+		decrypted[i] = h
+	}
+
+	return decrypted, nil
+}
+
+// History returns the previous values of the given key, starting with the most recent one. Secrets
+// are decrypted, unless skipDecryption is set.
+func (c *ConfigstoreClient) History(key string, skipDecryption bool) ([]HistoryEntry, error) {
+	entry, exists := c.db.Data[key]
+	if !exists {
+		return nil, errors.New("key does not exist in Configstore: " + key)
+	}
+
+	history := make([]HistoryEntry, len(entry.History))
+	copy(history, entry.History)
+
+	for i, h := range history {
+		if !h.IsSecret {
+			continue
+		}
+
+		if skipDecryption {
+			history[i].Value = "(secret)"
+			continue
+		}
+
+		if err := c.initEncryption(); err != nil {
+			return nil, err
+		}
+
+		value, err := c.decryptSecret(key, h.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%w; Failed to decrypt previous value %d for key: %s", err, i+1, key)
+		}
+
+		history[i].Value = value
+	}
+
+	return history, nil
+}
+
+// Rollback restores the nth previous value of the given key (1 being the most recent one). The value
+// being replaced is added to the history, so a rollback can itself be rolled back.
+func (c *ConfigstoreClient) Rollback(key string, n int) error {
+	b := c.Batch()
+	if err := b.Rollback(key, n); err != nil {
+		return err
+	}
+
+	return b.Commit()
+}
+
+// applyRollback restores the nth previous value of the given entry
+func (c *ConfigstoreClient) applyRollback(key string, entry ConfigstoreDBValue, n int) (ConfigstoreDBValue, error) {
+	if n < 1 || n > len(entry.History) {
+		return entry, fmt.Errorf("no previous value %d for key: %s (%d available)", n, key, len(entry.History))
+	}
+
+	previous := entry.History[n-1]
+	now := time.Now().UTC().Truncate(time.Second)
+
+	entry.History = entry.pushHistory(now)
+	entry.Value = previous.Value
+	entry.IsSecret = previous.IsSecret
+	entry.IsBinary = previous.IsBinary
+	entry.UpdatedAt = &now
+
+	if entry.IsSecret {
+		if err := c.initEncryption(); err != nil {
+			return entry, err
+		}
+
+		history, err := c.encryptHistory(key, entry.History)
+		if err != nil {
+			return entry, err
+		}
+
+		entry.History = history
+	}
+
+	// The previous value may predate the type declared for the key
+	if entry.Type != "" {
+		value, err := c.plaintextValue(key, entry)
+		if err != nil {
+			return entry, err
+		}
+
+		if err := validateEntry(key, entry.Type, entry.IsBinary, value); err != nil {
+			return entry, err
+		}
+	}
+
+	return entry, nil
+}
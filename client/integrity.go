@@ -0,0 +1,77 @@
+package client
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/hkdf"
+	"io"
+	"strings"
+)
+
+// A Configstore DB can optionally be protected by an integrity tag: an HMAC over the canonical JSON form of
+// the whole DB, keyed from the Data Key. This means that adding, removing or modifying entries outside of
+// Configstore (even plain text ones) is detected, at the cost of having to decrypt the Data Key whenever
+// the DB is saved.
+
+const integrityTagPrefix = "hmac-sha256:"
+
+var errIntegrityCheckFailed = errors.New("integrity check failed; the Configstore DB has been modified outside of Configstore")
+
+// WithRequireIntegrity makes the client refuse to load a Configstore DB which isn't protected by an
+// integrity tag, or which fails verification
+func WithRequireIntegrity() ClientOption {
+	return func(c *ConfigstoreClient) {
+		c.requireIntegrity = true
+	}
+}
+
+// integrityKey derives the key used for the integrity tag, so that the Data Key itself is never used for
+// anything other than encrypting values
+func integrityKey(dataKey []byte) ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, dataKey, nil, []byte("configstore integrity")), key); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// integrityCanonical returns the canonical form of the DB covered by the integrity tag: its JSON encoding,
+// without the tag itself. Map keys are always sorted by encoding/json, so this is stable.
+func integrityCanonical(db ConfigstoreDB) ([]byte, error) {
+	db.Integrity = ""
+	return json.Marshal(db)
+}
+
+func computeIntegrityTag(canonical []byte, dataKey []byte) (string, error) {
+	key, err := integrityKey(dataKey)
+	if err != nil {
+		return "", fmt.Errorf("%w; Failed to derive integrity key", err)
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(canonical)
+
+	return integrityTagPrefix + base64.StdEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+func verifyIntegrityTag(canonical []byte, tag string, dataKey []byte) error {
+	if !strings.HasPrefix(tag, integrityTagPrefix) {
+		return errors.New("unsupported integrity tag format")
+	}
+
+	expected, err := computeIntegrityTag(canonical, dataKey)
+	if err != nil {
+		return err
+	}
+
+	if !hmac.Equal([]byte(expected), []byte(tag)) {
+		return errIntegrityCheckFailed
+	}
+
+	return nil
+}
@@ -0,0 +1,45 @@
+package client
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Changes to a Configstore DB are made in a load-modify-save cycle, which (for DBs stored in a local file) is
+// protected by an advisory lock, so that concurrent changes (from separate processes or clients) are never lost.
+// The lock is held on a separate file next to the DB, since the DB file itself is replaced whenever it's saved.
+
+type dbLock struct {
+	file *os.File
+}
+
+// lockFilePath returns the path of the lock file used for the given DB file
+func lockFilePath(dbFile string) string {
+	return filepath.Join(filepath.Dir(dbFile), "."+filepath.Base(dbFile)+".lock")
+}
+
+// lockDB takes an exclusive lock for the given DB file, waiting for any other holder to release it first
+func lockDB(dbFile string) (*dbLock, error) {
+	f, err := os.OpenFile(lockFilePath(dbFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("%w; Failed to open lock file for DB: %s", err, dbFile)
+	}
+
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%w; Failed to lock DB: %s", err, dbFile)
+	}
+
+	return &dbLock{file: f}, nil
+}
+
+func (l *dbLock) unlock() error {
+	// The lock file is deliberately left behind: removing it could let two processes hold a lock at the same time
+	if err := unlockFile(l.file); err != nil {
+		l.file.Close()
+		return err
+	}
+
+	return l.file.Close()
+}
@@ -0,0 +1,433 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+)
+
+// Configstore DBs and override files can be merged key by key, for use as a git merge driver: changes made to
+// different keys on two branches never conflict, and neither do identical changes made to the same key on both
+// branches. Since the ciphertext of a secret is different every time it's encrypted, secrets changed on both
+// branches are compared by their decrypted value when the Data Key is accessible, and reported as a conflict
+// when it isn't.
+
+// MergeConflict is a key which was changed differently on both sides of a merge. An empty key means the settings
+// of the Configstore DB itself (the Data Key, KMS settings and so on).
+type MergeConflict struct {
+	Key    string
+	Reason string
+}
+
+// The contents of a file being merged: either a Configstore DB, or an override file
+type mergeFile struct {
+	db        *ConfigstoreDB
+	overrides map[string]string
+}
+
+func (f mergeFile) isDB() bool {
+	return f.db != nil
+}
+
+func (f mergeFile) isEmpty() bool {
+	return f.db == nil && f.overrides == nil
+}
+
+// One side of a merge, with a client for decrypting its secrets on demand
+type mergeSide struct {
+	file   string
+	db     ConfigstoreDB
+	opts   []ClientOption
+	client *ConfigstoreClient
+	err    error // Why secrets can't be decrypted, once that has been attempted
+}
+
+// MergeFiles does a three-way merge of the Configstore DBs (or override files) in oursFile and theirsFile, which
+// both derive from baseFile, and writes the result to oursFile. The base file may be empty, if the file was added on
+// both sides. Conflicting keys keep the value from oursFile, and are returned, so the result is always a valid file.
+// The storage format of the files is picked based on path, which is the name of the file being merged (the copies
+// git passes to merge drivers have no extension); if empty, oursFile is used instead. The client options are used
+// for decrypting secrets, which is only attempted when a secret was changed on both sides; pass skipDecryption to
+// treat these as conflicts straight away.
+func MergeFiles(baseFile string, oursFile string, theirsFile string, path string, skipDecryption bool, opts ...ClientOption) ([]MergeConflict, error) {
+	if path == "" {
+		path = oursFile
+	}
+
+	format := FormatForFile(path)
+
+	base, err := loadMergeFile(baseFile, format)
+	if err != nil {
+		return nil, err
+	}
+
+	ours, err := loadMergeFile(oursFile, format)
+	if err != nil {
+		return nil, err
+	}
+
+	theirs, err := loadMergeFile(theirsFile, format)
+	if err != nil {
+		return nil, err
+	}
+
+	if ours.isEmpty() || theirs.isEmpty() {
+		return nil, errors.New("cannot merge empty files")
+	}
+
+	if ours.isDB() != theirs.isDB() || !base.isEmpty() && base.isDB() != ours.isDB() {
+		return nil, errors.New("cannot merge a Configstore DB with an override file")
+	}
+
+	if ours.overrides != nil {
+		merged, conflicts := mergeOverrides(base.overrides, ours.overrides, theirs.overrides)
+
+		jsonStr, err := json.MarshalIndent(merged, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+
+		return conflicts, ioutil.WriteFile(oursFile, jsonStr, 0644)
+	}
+
+	if base.db == nil {
+		base.db = &ConfigstoreDB{Data: make(map[string]ConfigstoreDBValue)}
+	}
+
+	if skipDecryption {
+		opts = nil
+	}
+
+	oursSide := &mergeSide{file: oursFile, db: *ours.db, opts: opts}
+	theirsSide := &mergeSide{file: theirsFile, db: *theirs.db, opts: opts}
+
+	if skipDecryption {
+		oursSide.err = errors.New("decryption skipped")
+		theirsSide.err = oursSide.err
+	}
+
+	merged, conflicts, err := mergeDBs(*base.db, oursSide, theirsSide)
+	if err != nil {
+		return nil, err
+	}
+
+	// The layout of our version is kept (see codec.go)
+	existing, err := ioutil.ReadFile(oursFile)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := codecs[format].encode(merged, existing)
+	if err != nil {
+		return nil, fmt.Errorf("%w; Failed to marshal merged Configstore DB into %s", err, format)
+	}
+
+	return conflicts, ioutil.WriteFile(oursFile, data, 0644)
+}
+
+// loadMergeFile works out whether the given file holds a Configstore DB (in the given format) or overrides, and
+// loads it accordingly
+func loadMergeFile(path string, format string) (mergeFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return mergeFile{}, fmt.Errorf("%w; Failed to read file for merge: %s", err, path)
+	}
+
+	if len(bytes.TrimSpace(data)) == 0 {
+		return mergeFile{}, nil
+	}
+
+	var fields map[string]interface{}
+	if err := codecs[format].decode(data, &fields); err != nil {
+		return mergeFile{}, fmt.Errorf("%w; Failed to unmarshal %s from file for merge: %s", err, format, path)
+	}
+
+	// Override values are always strings, whereas the version of a DB is a number
+	if version, exists := fields["version"]; exists {
+		if _, isString := version.(string); !isString {
+			var db ConfigstoreDB
+			if err := codecs[format].decode(data, &db); err != nil {
+				return mergeFile{}, fmt.Errorf("%w; Failed to unmarshal %s from file for merge: %s", err, format, path)
+			}
+
+			db, err := db.validate()
+			if err != nil {
+				return mergeFile{}, err
+			}
+
+			return mergeFile{db: &db}, nil
+		}
+	}
+
+	overrides, err := loadOverride(path)
+	if err != nil {
+		return mergeFile{}, fmt.Errorf("%w; Failed to load override file for merge: %s", err, path)
+	}
+
+	return mergeFile{overrides: overrides}, nil
+}
+
+// threeWay picks the side to take for a single item, given whether each pair of versions is the same. Returns
+// "ours", "theirs", or "" for a conflict.
+func threeWay(oursIsTheirs bool, baseIsOurs bool, baseIsTheirs bool) string {
+	switch {
+	case oursIsTheirs:
+		return "ours"
+	case baseIsOurs:
+		return "theirs"
+	case baseIsTheirs:
+		return "ours"
+	default:
+		return ""
+	}
+}
+
+func mergeOverrides(base map[string]string, ours map[string]string, theirs map[string]string) (map[string]string, []MergeConflict) {
+	merged := make(map[string]string)
+	conflicts := make([]MergeConflict, 0)
+
+	keys := make(map[string]bool)
+	for _, m := range []map[string]string{base, ours, theirs} {
+		for k := range m {
+			keys[k] = true
+		}
+	}
+
+	for _, k := range sortedKeys(keys) {
+		b, inBase := base[k]
+		o, inOurs := ours[k]
+		t, inTheirs := theirs[k]
+
+		same := func(x string, xok bool, y string, yok bool) bool {
+			return xok == yok && x == y
+		}
+
+		side := threeWay(same(o, inOurs, t, inTheirs), same(b, inBase, o, inOurs), same(b, inBase, t, inTheirs))
+
+		if side == "" {
+			conflicts = append(conflicts, MergeConflict{Key: k, Reason: "changed on both sides"})
+			side = "ours"
+		}
+
+		if side == "ours" && inOurs {
+			merged[k] = o
+		} else if side == "theirs" && inTheirs {
+			merged[k] = t
+		}
+	}
+
+	return merged, conflicts
+}
+
+func sortedKeys(keys map[string]bool) []string {
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+
+	sort.Strings(sorted)
+	return sorted
+}
+
+func mergeDBs(base ConfigstoreDB, ours *mergeSide, theirs *mergeSide) (ConfigstoreDB, []MergeConflict, error) {
+	conflicts := make([]MergeConflict, 0)
+
+	// The settings of the DB (everything but the values) are merged as a whole
+	baseSettings, oursSettings, theirsSettings := dbSettings(base), dbSettings(ours.db), dbSettings(theirs.db)
+
+	settingsFrom := threeWay(
+		reflect.DeepEqual(oursSettings, theirsSettings),
+		reflect.DeepEqual(baseSettings, oursSettings),
+		reflect.DeepEqual(baseSettings, theirsSettings),
+	)
+
+	if settingsFrom == "" {
+		conflicts = append(conflicts, MergeConflict{Reason: "Configstore DB settings changed on both sides"})
+		settingsFrom = "ours"
+	}
+
+	main, other := ours, theirs
+	if settingsFrom == "theirs" {
+		main, other = theirs, ours
+	}
+
+	sameKeys := reflect.DeepEqual(oursSettings, theirsSettings)
+
+	merged := main.db
+	merged.Data = make(map[string]ConfigstoreDBValue)
+
+	keys := make(map[string]bool)
+	for _, data := range []map[string]ConfigstoreDBValue{base.Data, ours.db.Data, theirs.db.Data} {
+		for k := range data {
+			keys[k] = true
+		}
+	}
+
+	for _, k := range sortedKeys(keys) {
+		b, inBase := base.Data[k]
+		o, inOurs := ours.db.Data[k]
+		t, inTheirs := theirs.db.Data[k]
+
+		oursIsTheirs, reason := sameEntry(k, ours, o, inOurs, theirs, t, inTheirs)
+		baseIsOurs, _ := sameEntry(k, nil, b, inBase, ours, o, inOurs)
+		baseIsTheirs, _ := sameEntry(k, nil, b, inBase, theirs, t, inTheirs)
+
+		side := threeWay(oursIsTheirs, baseIsOurs, baseIsTheirs)
+
+		if side == "" {
+			conflicts = append(conflicts, MergeConflict{Key: k, Reason: reason})
+			side = "ours"
+		}
+
+		// Identical changes are taken from the side the DB settings come from, so that secrets match its Data Key
+		if oursIsTheirs {
+			side = settingsFrom
+		}
+
+		from, entry, exists := ours, o, inOurs
+		if side == "theirs" {
+			from, entry, exists = theirs, t, inTheirs
+		}
+
+		if !exists {
+			continue
+		}
+
+		// Secrets from the other side may have been encrypted with a different Data Key, or bound to a different DB
+		if from == other && !sameKeys && entryEncrypted(entry) && !reflect.DeepEqual(entry, main.db.Data[k]) {
+			conflicts = append(conflicts, MergeConflict{Key: k, Reason: "secret was changed on one side, while the Data Key was changed on the other"})
+
+			if entry, exists = main.db.Data[k]; !exists {
+				continue
+			}
+		}
+
+		merged.Data[k] = entry
+	}
+
+	// The integrity tag has to be recomputed for the merged values, which needs the Data Key
+	if merged.Integrity != "" {
+		c, err := main.decryptionClient()
+		if err != nil {
+			conflicts = append(conflicts, MergeConflict{Reason: "Configstore DB is protected by an integrity tag, which cannot be recomputed without access to the Data Key"})
+			return merged, conflicts, nil
+		}
+
+		canonical, err := integrityCanonical(merged)
+		if err != nil {
+			return merged, conflicts, err
+		}
+
+		tag, err := computeIntegrityTag(canonical, c.encryption.dataKey)
+		if err != nil {
+			return merged, conflicts, err
+		}
+
+		merged.Integrity = tag
+	}
+
+	return merged, conflicts, nil
+}
+
+// dbSettings returns the DB without its values and integrity tag
+func dbSettings(db ConfigstoreDB) ConfigstoreDB {
+	db.Data = nil
+	db.Integrity = ""
+	return db
+}
+
+// entryEncrypted checks whether the entry holds any encrypted values, including previous ones
+func entryEncrypted(entry ConfigstoreDBValue) bool {
+	if entry.IsSecret {
+		return true
+	}
+
+	for _, h := range entry.History {
+		if h.IsSecret {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sameEntry checks whether two versions of an entry hold the same value and metadata. Timestamps and previous
+// values are ignored. Secrets with different ciphertexts are decrypted to compare them, if both sides are given;
+// if that isn't possible, they're reported as different, along with the reason.
+func sameEntry(key string, xSide *mergeSide, x ConfigstoreDBValue, xExists bool, ySide *mergeSide, y ConfigstoreDBValue, yExists bool) (bool, string) {
+	if !xExists || !yExists {
+		if xExists != yExists {
+			return false, "changed on one side, and removed on the other"
+		}
+
+		return true, ""
+	}
+
+	if x.IsSecret != y.IsSecret || x.IsBinary != y.IsBinary || x.Type != y.Type || x.Description != y.Description ||
+		x.Owner != y.Owner || !reflect.DeepEqual(normaliseTags(x.Tags), normaliseTags(y.Tags)) {
+		return false, "changed on both sides"
+	}
+
+	if x.Value == y.Value {
+		return true, ""
+	}
+
+	if !x.IsSecret || xSide == nil || ySide == nil {
+		return false, "changed on both sides"
+	}
+
+	xValue, err := xSide.decrypt(key, x.Value)
+	if err != nil {
+		return false, "secret changed on both sides, and could not be decrypted to compare (" + err.Error() + ")"
+	}
+
+	yValue, err := ySide.decrypt(key, y.Value)
+	if err != nil {
+		return false, "secret changed on both sides, and could not be decrypted to compare (" + err.Error() + ")"
+	}
+
+	if xValue != yValue {
+		return false, "secret changed on both sides"
+	}
+
+	return true, ""
+}
+
+// decryptionClient returns a client for the DB on this side of the merge, with its encryption initialised
+func (s *mergeSide) decryptionClient() (*ConfigstoreClient, error) {
+	if s.client != nil || s.err != nil {
+		return s.client, s.err
+	}
+
+	c := &ConfigstoreClient{
+		storage:   &fileStorage{path: s.file},
+		db:        s.db,
+		agent:     agentFromEnv(),
+		overrides: make(map[string]string),
+	}
+
+	for _, opt := range s.opts {
+		opt(c)
+	}
+
+	if err := c.initEncryption(); err != nil {
+		s.err = err
+		return nil, err
+	}
+
+	s.client = c
+	return c, nil
+}
+
+func (s *mergeSide) decrypt(key string, value string) (string, error) {
+	c, err := s.decryptionClient()
+	if err != nil {
+		return "", err
+	}
+
+	return c.decryptSecret(key, value)
+}
@@ -0,0 +1,143 @@
+package client
+
+import (
+	"fmt"
+)
+
+// Older Configstore DBs are upgraded by running each migration in the registry below which is newer than the DB.
+// When a DB is loaded, only the migrations which don't need the Data Key are applied (in memory), since unwrapping
+// it and re-encrypting every secret is too costly to do on every load; secrets in the older formats are decrypted
+// as they are, when they're read. Loading a DB never writes to the DB file: changes to an old DB are refused until
+// it has been upgraded via Migrate, so that read-only commands work on read-only file systems, and checked in DB
+// files are only ever rewritten on purpose.
+
+// Migration upgrades a Configstore DB from the previous version to Version
+type Migration struct {
+	Version     int
+	Description string
+	apply       func(c *ConfigstoreClient) error
+	// Whether the migration has to unwrap the Data Key, so it's only applied by Migrate
+	needsDataKey bool
+}
+
+// All migrations, in order; the last one upgrades to latestVersion
+var migrations = []Migration{
+	{2, "Record the ID of the KMS Master Key", (*ConfigstoreClient).migrateToV2, true},
+	{3, "Add the attributes introduced in version 3", func(*ConfigstoreClient) error { return nil }, false},
+	{4, "Re-encrypt secrets stored in the legacy (unauthenticated) format with AES-GCM", (*ConfigstoreClient).migrateToV4, true},
+	{5, "Bind every secret to the name of its key, and the identity of the DB", (*ConfigstoreClient).migrateToV5, true},
+}
+
+// migrate upgrades the DB held by the client in memory: to the latest version if full is set, and otherwise only
+// up to the first migration which needs the Data Key
+func (c *ConfigstoreClient) migrate(full bool) error {
+	if c.db.Version >= latestVersion {
+		return nil
+	}
+
+	// Only version 5 DBs have an identity, so one which claims to be older has had its version lowered by hand,
+	// likely to get legacy values past decryption (see ConfigstoreDB.minCiphertextVersion); migrating it would
+	// re-encrypt those for good
+	if full && c.db.Id != "" {
+		return fmt.Errorf("the Configstore DB has an identity, so it cannot be at version %d; its version has been tampered with", c.db.Version)
+	}
+
+	// Secrets written by any of the migrations are bound to the DB identity, so it has to be
+	// in place before they run
+	if full {
+		id, err := generateDBId()
+		if err != nil {
+			return err
+		}
+		c.db.Id = id
+	}
+
+	for _, m := range migrations {
+		if m.Version <= c.db.Version {
+			continue
+		}
+
+		if m.needsDataKey && !full {
+			return nil
+		}
+
+		if err := m.apply(c); err != nil {
+			return fmt.Errorf("%w; Failed to migrate Configstore DB to version %d", err, m.Version)
+		}
+
+		c.db.Version = m.Version
+	}
+
+	return nil
+}
+
+// PendingMigrations returns the migrations which haven't been written to the DB file yet (some of which may have
+// been applied in memory when the DB was loaded)
+func (c *ConfigstoreClient) PendingMigrations() []Migration {
+	pending := make([]Migration, 0)
+
+	if c.migratedFrom == 0 {
+		return pending
+	}
+
+	for _, m := range migrations {
+		if m.Version > c.migratedFrom {
+			pending = append(pending, m)
+		}
+	}
+
+	return pending
+}
+
+// Migrate writes the DB file upgraded to the latest version, and returns the migrations which were applied. Nothing
+// is written if the DB file is already at the latest version.
+func (c *ConfigstoreClient) Migrate() ([]Migration, error) {
+	unlock, err := c.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	pending := c.PendingMigrations()
+	if len(pending) == 0 {
+		return pending, nil
+	}
+
+	if err := c.migrate(true); err != nil {
+		return nil, err
+	}
+
+	c.migratedFrom = 0
+
+	if err := c.save(); err != nil {
+		c.migratedFrom = pending[0].Version - 1
+		return nil, err
+	}
+
+	return pending, nil
+}
+
+func (c *ConfigstoreClient) migrateToV2() error {
+	err := c.initEncryption()
+	if err != nil {
+		return err
+	}
+
+	if p, ok := c.encryption.provider.(*kmsKeyProvider); ok {
+		c.db.MasterKeyId = p.masterKeyId
+	}
+
+	return nil
+}
+
+// migrateToV4 re-encrypts all secrets stored in the legacy (unauthenticated) format
+// using AES-GCM. DBs without any secrets can be upgraded without decryption.
+func (c *ConfigstoreClient) migrateToV4() error {
+	return c.upgradeSecrets(ciphertextV1)
+}
+
+// migrateToV5 binds every secret to the name of its key (and the identity of the DB), so that
+// ciphertexts can no longer be swapped between keys
+func (c *ConfigstoreClient) migrateToV5() error {
+	return c.upgradeSecrets(ciphertextV2)
+}
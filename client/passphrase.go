@@ -0,0 +1,191 @@
+package client
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/scrypt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const PassphraseKeyProviderName = "passphrase"
+
+// Environment variables which can be used to supply the passphrase non-interactively (on a CI server for example)
+const (
+	PassphraseEnvVar     = "CONFIGSTORE_PASSPHRASE"
+	PassphraseFileEnvVar = "CONFIGSTORE_PASSPHRASE_FILE"
+)
+
+// Default scrypt cost parameters for new Configstores, as recommended for interactive logins
+const (
+	scryptN       = 32768
+	scryptR       = 8
+	scryptP       = 1
+	scryptSaltLen = 16
+)
+
+// PassphraseParams holds the settings used for deriving the key which wraps the Data Key from a passphrase
+type PassphraseParams struct {
+	KDF  string `json:"kdf"`
+	Salt string `json:"salt"`
+	N    int    `json:"n"`
+	R    int    `json:"r"`
+	P    int    `json:"p"`
+}
+
+// PassphraseFunc is used to ask the user for the passphrase of a Configstore
+type PassphraseFunc func() ([]byte, error)
+
+// WithPassphraseFunc sets the function used to ask for the passphrase of a passphrase-protected Configstore,
+// if one wasn't provided via the CONFIGSTORE_PASSPHRASE or CONFIGSTORE_PASSPHRASE_FILE environment variables
+func WithPassphraseFunc(f PassphraseFunc) ClientOption {
+	return func(c *ConfigstoreClient) {
+		c.providerConfig.PassphraseFunc = f
+	}
+}
+
+// PassphraseFromEnv returns the passphrase set via the CONFIGSTORE_PASSPHRASE environment variable, or read from the
+// file pointed to by CONFIGSTORE_PASSPHRASE_FILE. It returns nil if neither of these are set.
+func PassphraseFromEnv() ([]byte, error) {
+	if p := os.Getenv(PassphraseEnvVar); p != "" {
+		return []byte(p), nil
+	}
+
+	if path := os.Getenv(PassphraseFileEnvVar); path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("%w; Failed to read passphrase file: %s", err, path)
+		}
+
+		return []byte(strings.TrimRight(string(b), "\r\n")), nil
+	}
+
+	return nil, nil
+}
+
+func newPassphraseParams() (*PassphraseParams, error) {
+	salt := make([]byte, scryptSaltLen)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+
+	return &PassphraseParams{
+		KDF:  "scrypt",
+		Salt: base64.StdEncoding.EncodeToString(salt),
+		N:    scryptN,
+		R:    scryptR,
+		P:    scryptP,
+	}, nil
+}
+
+func (p PassphraseParams) deriveKey(passphrase []byte) ([]byte, error) {
+	if p.KDF != "scrypt" {
+		return nil, errors.New("unsupported key derivation function: " + p.KDF)
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(p.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("%w; Failed to decode passphrase salt", err)
+	}
+
+	return scrypt.Key(passphrase, salt, p.N, p.R, p.P, 32)
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////
+// Key Provider
+
+// passphraseKeyProvider wraps the Data Key with AES-GCM, using a key derived from a passphrase
+type passphraseKeyProvider struct {
+	key []byte
+}
+
+func newPassphraseKeyProvider(w *DataKeyWrapping, config ProviderConfig) (KeyProvider, error) {
+	if w.Passphrase == nil {
+		return nil, errors.New("missing key in Configstore DB: passphrase")
+	}
+
+	passphrase, err := PassphraseFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	if passphrase == nil {
+		if config.PassphraseFunc == nil {
+			return nil, errors.New("no passphrase available; set " + PassphraseEnvVar + " or " + PassphraseFileEnvVar)
+		}
+
+		passphrase, err = config.PassphraseFunc()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return createPassphraseKeyProvider(*w.Passphrase, passphrase)
+}
+
+func createPassphraseKeyProvider(params PassphraseParams, passphrase []byte) (*passphraseKeyProvider, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("passphrase cannot be empty")
+	}
+
+	key, err := params.deriveKey(passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	return &passphraseKeyProvider{
+		key: key,
+	}, nil
+}
+
+func (p *passphraseKeyProvider) Name() string {
+	return PassphraseKeyProviderName
+}
+
+func (p *passphraseKeyProvider) gcm() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(p.key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func (p *passphraseKeyProvider) WrapDataKey(dataKey []byte) ([]byte, error) {
+	gcm, err := p.gcm()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, dataKey, nil), nil
+}
+
+func (p *passphraseKeyProvider) UnwrapDataKey(wrapped []byte) ([]byte, error) {
+	gcm, err := p.gcm()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(wrapped) < gcm.NonceSize() {
+		return nil, errors.New("wrapped Data Key too short")
+	}
+
+	dataKey, err := gcm.Open(nil, wrapped[:gcm.NonceSize()], wrapped[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New("incorrect passphrase")
+	}
+
+	return dataKey, nil
+}
@@ -0,0 +1,146 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// KeyProvider is responsible for protecting the Data Key of a Configstore: the Data Key is "wrapped"
+// (encrypted) before it's stored in the DB, and "unwrapped" whenever secrets need to be encrypted or decrypted.
+type KeyProvider interface {
+	// Name is the identifier under which the provider is registered, and which is recorded in the Configstore DB
+	Name() string
+	WrapDataKey(dataKey []byte) ([]byte, error)
+	UnwrapDataKey(wrapped []byte) ([]byte, error)
+}
+
+// DataKeyGenerator may be implemented by a KeyProvider which can generate new Data Keys itself (like AWS KMS).
+// Providers which don't implement it get a Data Key generated locally, which is then wrapped.
+type DataKeyGenerator interface {
+	GenerateDataKey() (plaintext []byte, wrapped []byte, err error)
+}
+
+// ValueEncrypter may be implemented by a KeyProvider which can encrypt arbitrary values with its master key.
+// This is what's used to implement GetAsKMSEncrypted.
+type ValueEncrypter interface {
+	EncryptValue(value []byte) (string, error)
+}
+
+// ProviderConfig holds the client-side settings which are passed to a KeyProviderFactory, on top of
+// what's stored in the Configstore DB
+type ProviderConfig struct {
+	// Do not assume the IAM Role stored in the DB (where applicable)
+	IgnoreRole bool
+	// Used to ask for the passphrase of passphrase-protected Configstores
+	PassphraseFunc PassphraseFunc
+	// The identity files used for decrypting the Data Key of age-backed Configstores
+	AgeIdentityFiles []string
+	// Overrides for the AWS settings stored in the DB (only the fields which are set)
+	AWS AWSSettings
+	// Used to ask for the MFA token code when assuming an IAM Role which requires MFA
+	MFATokenFunc MFATokenFunc
+}
+
+// KeyProviderFactory creates a KeyProvider for unwrapping a given copy of the Data Key
+type KeyProviderFactory func(w *DataKeyWrapping, config ProviderConfig) (KeyProvider, error)
+
+const (
+	InsecureKeyProviderName = "insecure"
+	KMSKeyProviderName      = "kms"
+)
+
+var (
+	keyProvidersMu sync.RWMutex
+	keyProviders   = map[string]KeyProviderFactory{
+		InsecureKeyProviderName:   newInsecureKeyProvider,
+		KMSKeyProviderName:        newKMSKeyProvider,
+		PassphraseKeyProviderName: newPassphraseKeyProvider,
+		AgeKeyProviderName:        newAgeKeyProvider,
+	}
+)
+
+// RegisterKeyProvider makes a KeyProvider available under the given name, so that Configstore DBs which
+// record that name can be opened. Registering a name twice replaces the previous factory.
+func RegisterKeyProvider(name string, factory KeyProviderFactory) {
+	keyProvidersMu.Lock()
+	defer keyProvidersMu.Unlock()
+
+	keyProviders[name] = factory
+}
+
+// keyProviderName returns the name of the KeyProvider used by the given DB. DBs created before key
+// providers were introduced don't record one, so we work it out from the insecure flag.
+func keyProviderName(db *ConfigstoreDB) string {
+	if db.KeyProvider != "" {
+		return db.KeyProvider
+	}
+
+	if db.IsInsecure {
+		return InsecureKeyProviderName
+	}
+
+	return KMSKeyProviderName
+}
+
+func createKeyProvider(w *DataKeyWrapping, config ProviderConfig) (KeyProvider, error) {
+	name := w.KeyProvider
+
+	keyProvidersMu.RLock()
+	factory, exists := keyProviders[name]
+	keyProvidersMu.RUnlock()
+
+	if !exists {
+		return nil, errors.New("unknown key provider: " + name)
+	}
+
+	provider, err := factory(w, config)
+	if err != nil {
+		return nil, fmt.Errorf("%w; Failed to initialise key provider: %s", err, name)
+	}
+
+	return provider, nil
+}
+
+// generateDataKey creates a new Data Key via the given provider, returning both the plain text
+// version, and the wrapped version that can be stored in the DB
+func generateDataKey(provider KeyProvider) ([]byte, []byte, error) {
+	if generator, ok := provider.(DataKeyGenerator); ok {
+		return generator.GenerateDataKey()
+	}
+
+	plaintext, err := generateLocalDataKey()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	wrapped, err := provider.WrapDataKey(plaintext)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return plaintext, wrapped, nil
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////
+// Insecure
+
+// insecureKeyProvider stores the Data Key in plain text; see `--insecure`
+type insecureKeyProvider struct{}
+
+func newInsecureKeyProvider(_ *DataKeyWrapping, _ ProviderConfig) (KeyProvider, error) {
+	return insecureKeyProvider{}, nil
+}
+
+func (p insecureKeyProvider) Name() string {
+	return InsecureKeyProviderName
+}
+
+func (p insecureKeyProvider) WrapDataKey(dataKey []byte) ([]byte, error) {
+	return dataKey, nil
+}
+
+func (p insecureKeyProvider) UnwrapDataKey(wrapped []byte) ([]byte, error) {
+	return wrapped, nil
+}
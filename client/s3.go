@@ -0,0 +1,142 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// s3Storage keeps the DB as an object in an S3 bucket (or any S3-compatible object store, like MinIO), addressed
+// as "s3://bucket/key". Settings can be passed as query parameters:
+//
+//	region:   The region of the bucket (defaults to the AWS_REGION environment variable)
+//	endpoint: Custom S3 endpoint, like a local MinIO server; path-style addressing is used for these
+//	profile:  Named profile from the shared AWS credentials/config files
+//
+// Instead of locking, changes are protected by the ETag of the object: every write is made conditional on
+// the object still having the ETag it had when it was read (or not existing yet, for new DBs).
+type s3Storage struct {
+	location string // Without the query parameters
+	bucket   string
+	key      string
+	service  *s3.S3
+}
+
+func newS3Storage(location string) (Storage, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("%w; Invalid S3 location", err)
+	}
+
+	bucket, key := u.Host, strings.TrimPrefix(u.Path, "/")
+	if bucket == "" || key == "" {
+		return nil, errors.New("S3 location has to be of the form s3://bucket/key, got: " + location)
+	}
+
+	query := u.Query()
+
+	region := query.Get("region")
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+
+	if region == "" {
+		return nil, errors.New("region has to be set for S3 storage, via the region parameter (s3://bucket/key?region=...) or AWS_REGION")
+	}
+
+	a, err := createAWSSession(region, "", AWSSettings{Profile: query.Get("profile")}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	config := aws.NewConfig()
+	if endpoint := query.Get("endpoint"); endpoint != "" {
+		config = config.WithEndpoint(endpoint).WithS3ForcePathStyle(true)
+	}
+
+	return &s3Storage{
+		location: "s3://" + bucket + "/" + key,
+		bucket:   bucket,
+		key:      key,
+		service:  s3.New(a.sess, config),
+	}, nil
+}
+
+func (s *s3Storage) Location() string {
+	return s.location
+}
+
+func (s *s3Storage) Read() ([]byte, string, error) {
+	out, err := s.service.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key),
+	})
+
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+		return nil, "", fmt.Errorf("%w; No Configstore DB in S3: %s", os.ErrNotExist, s.location)
+	}
+
+	if err != nil {
+		return nil, "", err
+	}
+	defer out.Body.Close()
+
+	b, err := ioutil.ReadAll(out.Body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return b, aws.StringValue(out.ETag), nil
+}
+
+func (s *s3Storage) Write(data []byte, version string) (string, error) {
+	req, out := s.service.PutObjectRequest(&s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(s.key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String(contentTypeForFile(s.key)),
+	})
+
+	// The SDK doesn't support conditional writes, so the headers are set by hand
+	if version == "" {
+		req.HTTPRequest.Header.Set("If-None-Match", "*")
+	} else {
+		req.HTTPRequest.Header.Set("If-Match", version)
+	}
+
+	if err := req.Send(); err != nil {
+		if rerr, ok := err.(awserr.RequestFailure); ok &&
+			(rerr.StatusCode() == http.StatusPreconditionFailed || rerr.StatusCode() == http.StatusConflict) {
+			return "", ErrConcurrentModification
+		}
+
+		return "", err
+	}
+
+	return aws.StringValue(out.ETag), nil
+}
+
+// Lock doesn't do anything, since writes are conditional on the version read instead
+func (s *s3Storage) Lock() (func() error, error) {
+	return func() error { return nil }, nil
+}
+
+// contentTypeForFile returns the MIME type for the storage format of the given DB file
+func contentTypeForFile(path string) string {
+	switch FormatForFile(path) {
+	case FormatYAML:
+		return "application/yaml"
+	case FormatTOML:
+		return "application/toml"
+	default:
+		return "application/json"
+	}
+}
@@ -0,0 +1,265 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// A Schema declares the keys which are expected in a set of Configstore DBs (the environments of a package), along
+// with the rules their values have to follow. Only plain text values can be checked against the allowed values and
+// pattern, since checking secrets would require decrypting them.
+
+type Schema struct {
+	Keys map[string]SchemaKey `json:"keys"`
+}
+
+type SchemaKey struct {
+	Description string   `json:"description,omitempty"`
+	Type        string   `json:"type,omitempty"`     // See ValueTypes
+	Required    bool     `json:"required,omitempty"` // Has to be set in every environment
+	Secret      bool     `json:"secret,omitempty"`   // Has to be stored as a secret
+	Allowed     []string `json:"allowed,omitempty"`  // The only values accepted, if set
+	Pattern     string   `json:"pattern,omitempty"`  // Regular expression that values have to match in full
+	Default     *string  `json:"default,omitempty"`  // Set for the key when a new environment is created
+
+	pattern *regexp.Regexp
+}
+
+// LoadSchema reads a Schema from the given JSON file, and checks that it's valid
+func LoadSchema(path string) (*Schema, error) {
+	jsonStr, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w; Failed to read schema file: %s", err, path)
+	}
+
+	var schema Schema
+
+	if err := json.Unmarshal(jsonStr, &schema); err != nil {
+		return nil, fmt.Errorf("%w; Failed to unmarshal json for schema file: %s", err, path)
+	}
+
+	if err := schema.init(); err != nil {
+		return nil, fmt.Errorf("%w; Invalid schema file: %s", err, path)
+	}
+
+	return &schema, nil
+}
+
+// Save writes the Schema to the given file as JSON
+func (s *Schema) Save(path string) error {
+	jsonStr, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, append(jsonStr, '\n'), 0644)
+}
+
+// init compiles the patterns in the schema, and makes sure that the rules for each key make sense
+func (s *Schema) init() error {
+	if s.Keys == nil {
+		s.Keys = make(map[string]SchemaKey)
+	}
+
+	for k, sk := range s.Keys {
+		if k == "" {
+			return errors.New("schema cannot declare an empty key")
+		}
+
+		if _, known := typeValidators[sk.Type]; sk.Type != "" && !known {
+			return fmt.Errorf("unknown value type for key %s: %s (available: %s)", k, sk.Type, strings.Join(ValueTypes(), ", "))
+		}
+
+		if sk.Pattern != "" {
+			re, err := regexp.Compile("^(?:" + sk.Pattern + ")$")
+			if err != nil {
+				return fmt.Errorf("%w; Invalid pattern for key: %s", err, k)
+			}
+
+			sk.pattern = re
+		}
+
+		for _, v := range sk.Allowed {
+			if err := sk.validatePlain(k, v, false); err != nil {
+				return fmt.Errorf("%w; Invalid allowed value in schema", err)
+			}
+		}
+
+		if sk.Default != nil {
+			if sk.Secret {
+				return errors.New("secret keys cannot have a default value in schema: " + k)
+			}
+
+			if err := sk.ValidateValue(k, *sk.Default); err != nil {
+				return fmt.Errorf("%w; Invalid default value in schema", err)
+			}
+		}
+
+		s.Keys[k] = sk
+	}
+
+	return nil
+}
+
+// Key returns the rules for the given key, or an error if the schema doesn't declare it
+func (s *Schema) Key(key string) (SchemaKey, error) {
+	sk, exists := s.Keys[key]
+	if !exists {
+		return SchemaKey{}, errors.New("key is not declared in schema: " + key)
+	}
+
+	return sk, nil
+}
+
+// ValidateValue checks a plain text value against the type, allowed values and pattern declared for the key
+func (sk SchemaKey) ValidateValue(key string, value string) error {
+	return sk.validatePlain(key, value, true)
+}
+
+func (sk SchemaKey) validatePlain(key string, value string, checkAllowed bool) error {
+	if err := validateEntry(key, sk.Type, false, value); err != nil {
+		return err
+	}
+
+	if checkAllowed && len(sk.Allowed) > 0 && !containsString(sk.Allowed, value) {
+		return fmt.Errorf("value for key %s has to be one of: %s", key, strings.Join(sk.Allowed, ", "))
+	}
+
+	if sk.pattern != nil && !sk.pattern.MatchString(value) {
+		return fmt.Errorf("value for key %s does not match pattern: %s", key, sk.Pattern)
+	}
+
+	return nil
+}
+
+// CheckEntry checks a value stored in a Configstore DB against the schema. The value of secrets isn't checked.
+func (s *Schema) CheckEntry(key string, entry ConfigstoreDBValue) error {
+	sk, err := s.Key(key)
+	if err != nil {
+		return err
+	}
+
+	if sk.Secret && !entry.IsSecret {
+		return errors.New("key has to be stored as a secret: " + key)
+	}
+
+	// Binary values are never typed (see validateEntry)
+	if entry.IsBinary {
+		if sk.Type != "" && sk.Type != TypeString {
+			return fmt.Errorf("binary value cannot be of type %s: %s", sk.Type, key)
+		}
+
+		return nil
+	}
+
+	if sk.Type != "" && entry.Type != sk.Type {
+		if entry.Type == "" {
+			return fmt.Errorf("key has to be declared as type %s: %s", sk.Type, key)
+		}
+
+		return fmt.Errorf("key is of type %s, but schema declares %s: %s", entry.Type, sk.Type, key)
+	}
+
+	if entry.IsSecret {
+		return nil
+	}
+
+	return sk.ValidateValue(key, entry.Value)
+}
+
+// CheckOverride checks an override value against the schema
+func (s *Schema) CheckOverride(key string, value string) error {
+	sk, err := s.Key(key)
+	if err != nil {
+		return err
+	}
+
+	if sk.Secret {
+		return errors.New("key has to be stored as a secret, and cannot be overridden: " + key)
+	}
+
+	return sk.ValidateValue(key, value)
+}
+
+// CheckDB checks every value in a Configstore DB against the schema, and makes sure that all required keys are set.
+// Entries are expected in the format returned by ConfigstoreClient.GetAll.
+func (s *Schema) CheckDB(entries map[string]ConfigstoreDBValue) error {
+	keys := make([]string, 0, len(entries))
+	for k := range entries {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if err := s.CheckEntry(k, entries[k]); err != nil {
+			return err
+		}
+	}
+
+	for _, k := range s.SortedKeys() {
+		if _, exists := entries[k]; s.Keys[k].Required && !exists {
+			return errors.New("required key is not set: " + k)
+		}
+	}
+
+	return nil
+}
+
+// SortedKeys returns all keys declared in the schema, in alphabetical order
+func (s *Schema) SortedKeys() []string {
+	keys := make([]string, 0, len(s.Keys))
+	for k := range s.Keys {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+// GenerateSchema creates a first schema from the values in a set of Configstore DBs (in the format returned by
+// ConfigstoreClient.GetAll). Keys set in every DB are required, keys which are secret in every DB have to be secret,
+// and keys declared with the same type everywhere get that type. Descriptions are taken from the key metadata.
+func GenerateSchema(dbs []map[string]ConfigstoreDBValue) *Schema {
+	schema := &Schema{Keys: make(map[string]SchemaKey)}
+	counts := make(map[string]int)
+	types := make(map[string]map[string]bool)
+
+	for _, entries := range dbs {
+		for k, e := range entries {
+			sk, seen := schema.Keys[k]
+			if !seen {
+				sk.Secret = true
+				types[k] = make(map[string]bool)
+			}
+
+			sk.Secret = sk.Secret && e.IsSecret
+			if sk.Description == "" {
+				sk.Description = e.Description
+			}
+
+			types[k][e.Type] = true
+			counts[k]++
+			schema.Keys[k] = sk
+		}
+	}
+
+	for k, sk := range schema.Keys {
+		sk.Required = counts[k] == len(dbs)
+
+		if len(types[k]) == 1 {
+			for t := range types[k] {
+				sk.Type = t
+			}
+		}
+
+		schema.Keys[k] = sk
+	}
+
+	return schema
+}
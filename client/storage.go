@@ -0,0 +1,176 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Storage is where the contents of a Configstore DB are kept. By default that's a file on the local disk, but
+// a DB can also be stored remotely, by passing a location of the form "<scheme>://..." instead of a path (like
+// "s3://bucket/key"); see RegisterStorage.
+//
+// Local files are protected by a lock for each load-modify-save cycle. Remote backends may use optimistic locking
+// instead: every read returns the version of the contents, which is passed back when writing, and the write fails
+// with ErrConcurrentModification if the DB was changed by someone else in the meantime.
+type Storage interface {
+	// Location identifies the DB in messages; its extension determines the storage format (see codec.go)
+	Location() string
+	// Read returns the contents of the DB, along with their version. An error matching os.ErrNotExist
+	// is returned if there's no DB at the location.
+	Read() ([]byte, string, error)
+	// Write replaces the contents of the DB, as long as they're still at the given version (an empty
+	// version means that there shouldn't be a DB yet), and returns the version of the new contents
+	Write(data []byte, version string) (string, error)
+	// Lock takes an exclusive lock on the DB for a load-modify-save cycle, and returns the function releasing it
+	Lock() (func() error, error)
+}
+
+// ErrConcurrentModification is returned when a DB couldn't be saved, because it was changed by someone else
+// since it was loaded. Reloading the DB and making the change again is safe.
+var ErrConcurrentModification = errors.New("the Configstore DB was changed by someone else in the meantime; try again")
+
+// StorageFactory creates a Storage for the given location
+type StorageFactory func(location string) (Storage, error)
+
+var (
+	storageBackendsMu sync.RWMutex
+	storageBackends   = map[string]StorageFactory{
+		"s3": newS3Storage,
+	}
+)
+
+// RegisterStorage makes a Storage backend available for locations starting with "<scheme>://". Registering a
+// scheme twice replaces the previous factory.
+func RegisterStorage(scheme string, factory StorageFactory) {
+	storageBackendsMu.Lock()
+	defer storageBackendsMu.Unlock()
+
+	storageBackends[scheme] = factory
+}
+
+// IsRemoteLocation returns true if the given DB location points to a storage backend, rather than a local file
+func IsRemoteLocation(location string) bool {
+	return strings.Contains(location, "://")
+}
+
+// OpenStorage returns the Storage for the given DB location: a local file, unless the location
+// starts with the scheme of a registered backend
+func OpenStorage(location string) (Storage, error) {
+	if location == "" {
+		return nil, errors.New("cannot open Configstore DB from empty path")
+	}
+
+	if !IsRemoteLocation(location) {
+		return &fileStorage{path: location}, nil
+	}
+
+	scheme := location[:strings.Index(location, "://")]
+
+	storageBackendsMu.RLock()
+	factory, exists := storageBackends[scheme]
+	storageBackendsMu.RUnlock()
+
+	if !exists {
+		return nil, errors.New("unknown storage backend: " + scheme)
+	}
+
+	s, err := factory(location)
+	if err != nil {
+		return nil, fmt.Errorf("%w; Failed to initialise storage backend for: %s", err, location)
+	}
+
+	return s, nil
+}
+
+// readIfExists returns the contents of the DB in the given Storage, or nil if there isn't one
+func readIfExists(s Storage) ([]byte, error) {
+	b, _, err := s.Read()
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%w; Failed to read DB: %s", err, s.Location())
+	}
+
+	return b, nil
+}
+
+///////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////
+// Local file
+
+// fileStorage keeps the DB in a file on the local disk. Versions aren't tracked, since changes are protected
+// by the lock instead.
+type fileStorage struct {
+	path string
+}
+
+func (s *fileStorage) Location() string {
+	return s.path
+}
+
+func (s *fileStorage) Read() ([]byte, string, error) {
+	b, err := ioutil.ReadFile(s.path)
+	return b, "", err
+}
+
+// Write writes (and syncs) the contents to a temporary file first, which is then moved into place, so
+// a failed write never leaves a truncated DB file behind. The permissions of an existing DB file are kept.
+func (s *fileStorage) Write(data []byte, _ string) (string, error) {
+	var mode os.FileMode = 0644
+
+	if info, err := os.Stat(s.path); err == nil {
+		mode = info.Mode().Perm()
+	} else if !os.IsNotExist(err) {
+		return "", fmt.Errorf("%w; Failed to check DB file: %s", err, s.path)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), "."+filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return "", fmt.Errorf("%w; Failed to create temporary file for DB: %s", err, s.path)
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("%w; Failed to write DB file: %s", err, s.path)
+	}
+
+	return "", nil
+}
+
+func (s *fileStorage) Lock() (func() error, error) {
+	l, err := lockDB(s.path)
+	if err != nil {
+		return nil, err
+	}
+
+	return l.unlock, nil
+}
@@ -0,0 +1,227 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Values are always stored as strings, but each entry can optionally declare the type of value it holds.
+// Typed values are validated whenever they're set (or overridden), and can be read via the typed getters.
+
+const (
+	TypeString   = "string"
+	TypeInt      = "int"
+	TypeFloat    = "float"
+	TypeBool     = "bool"
+	TypeDuration = "duration"
+	TypeURL      = "url"
+	TypeJSON     = "json"
+	TypeList     = "list" // Comma separated
+)
+
+var typeValidators = map[string]func(string) error{
+	TypeString: func(string) error { return nil },
+	TypeInt: func(s string) error {
+		_, err := strconv.ParseInt(s, 10, 64)
+		return err
+	},
+	TypeFloat: func(s string) error {
+		_, err := strconv.ParseFloat(s, 64)
+		return err
+	},
+	TypeBool: func(s string) error {
+		_, err := strconv.ParseBool(s)
+		return err
+	},
+	TypeDuration: func(s string) error {
+		_, err := time.ParseDuration(s)
+		return err
+	},
+	TypeURL: func(s string) error {
+		_, err := parseURL(s)
+		return err
+	},
+	TypeJSON: func(s string) error {
+		if !json.Valid([]byte(s)) {
+			return errors.New("invalid JSON")
+		}
+		return nil
+	},
+	TypeList: func(string) error { return nil },
+}
+
+// ValueTypes returns the names of all supported value types
+func ValueTypes() []string {
+	types := make([]string, 0, len(typeValidators))
+	for t := range typeValidators {
+		types = append(types, t)
+	}
+
+	sort.Strings(types)
+	return types
+}
+
+// ValidateValue checks that the given value is valid for the type. An empty type means an untyped value,
+// which is always valid.
+func ValidateValue(valueType string, value string) error {
+	if valueType == "" {
+		return nil
+	}
+
+	validate, exists := typeValidators[valueType]
+	if !exists {
+		return fmt.Errorf("unknown value type: %s (available: %s)", valueType, strings.Join(ValueTypes(), ", "))
+	}
+
+	if err := validate(value); err != nil {
+		return fmt.Errorf("%w; Value is not a valid %s", err, valueType)
+	}
+
+	return nil
+}
+
+// validateEntry checks a value against the declared type of the given key
+func validateEntry(key string, valueType string, isBinary bool, value string) error {
+	if isBinary && valueType != "" && valueType != TypeString {
+		return fmt.Errorf("binary value cannot be of type %s: %s", valueType, key)
+	}
+
+	if err := ValidateValue(valueType, value); err != nil {
+		return fmt.Errorf("%w; Invalid value for key: %s", err, key)
+	}
+
+	return nil
+}
+
+// parseURL only accepts absolute URLs, since pretty much any string is a valid relative URL
+func parseURL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme == "" || u.Host == "" {
+		return nil, errors.New("not an absolute URL")
+	}
+
+	return u, nil
+}
+
+// SetTyped stores a value under the given key, and declares its type. The value is validated against the
+// type before it's stored.
+func (c *ConfigstoreClient) SetTyped(key string, rawValue []byte, isSecret bool, valueType string) error {
+	b := c.Batch()
+	if err := b.SetTyped(key, rawValue, isSecret, valueType); err != nil {
+		return err
+	}
+
+	return b.Commit()
+}
+
+// SetType declares the type of an existing value, which has to be valid for the type. An empty type
+// makes the value untyped again.
+func (c *ConfigstoreClient) SetType(key string, valueType string) error {
+	b := c.Batch()
+	if err := b.SetType(key, valueType); err != nil {
+		return err
+	}
+
+	return b.Commit()
+}
+
+// getTyped returns the value for the given key, making sure that it wasn't declared with a different type
+func (c *ConfigstoreClient) getTyped(key string, valueType string) (string, error) {
+	value, err := c.Get(key)
+	if err != nil {
+		return "", err
+	}
+
+	if declared := c.db.Data[key].Type; declared != "" && declared != valueType {
+		return "", fmt.Errorf("key is of type %s, not %s: %s", declared, valueType, key)
+	}
+
+	if err := ValidateValue(valueType, value); err != nil {
+		return "", fmt.Errorf("%w; Invalid value for key: %s", err, key)
+	}
+
+	return value, nil
+}
+
+func (c *ConfigstoreClient) GetInt(key string) (int64, error) {
+	value, err := c.getTyped(key, TypeInt)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(value, 10, 64)
+}
+
+func (c *ConfigstoreClient) GetFloat(key string) (float64, error) {
+	value, err := c.getTyped(key, TypeFloat)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseFloat(value, 64)
+}
+
+func (c *ConfigstoreClient) GetBool(key string) (bool, error) {
+	value, err := c.getTyped(key, TypeBool)
+	if err != nil {
+		return false, err
+	}
+
+	return strconv.ParseBool(value)
+}
+
+func (c *ConfigstoreClient) GetDuration(key string) (time.Duration, error) {
+	value, err := c.getTyped(key, TypeDuration)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.ParseDuration(value)
+}
+
+func (c *ConfigstoreClient) GetURL(key string) (*url.URL, error) {
+	value, err := c.getTyped(key, TypeURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseURL(value)
+}
+
+// GetJSON unmarshals the JSON value of the given key into v
+func (c *ConfigstoreClient) GetJSON(key string, v interface{}) error {
+	value, err := c.getTyped(key, TypeJSON)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal([]byte(value), v)
+}
+
+// GetList splits the value of the given key on commas, trimming whitespace around each item
+func (c *ConfigstoreClient) GetList(key string) ([]string, error) {
+	value, err := c.getTyped(key, TypeList)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]string, 0)
+
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items, nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/motns/configstore/client"
+	"gopkg.in/urfave/cli.v1"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+func cmdAgent(c *cli.Context) error {
+	socket := c.String("socket")
+	ttl := c.Duration("ttl")
+	tempDir := c.Bool("temp-socket-dir")
+
+	if ttl <= 0 {
+		return errors.New("--ttl has to be a positive duration")
+	}
+
+	if socket == "" {
+		dir, err := ioutil.TempDir("", "configstore-agent")
+		if err != nil {
+			return err
+		}
+
+		socket = filepath.Join(dir, "agent.sock")
+		tempDir = true
+	}
+
+	if c.Bool("foreground") {
+		return runAgent(socket, ttl, tempDir)
+	}
+
+	// Start the agent as a separate process in the background, and print out the shell commands needed to use it
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	args := []string{"agent", "--foreground", "--socket", socket, "--ttl", ttl.String()}
+	if tempDir {
+		args = append(args, "--temp-socket-dir")
+	}
+
+	// The agent gets its own session, so that it keeps running after the terminal it was started from is closed
+	cmd := exec.Command(exe, args...)
+	detachProcess(cmd)
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("%w; Failed to start agent", err)
+	}
+
+	// Give the agent a chance to start listening, so that the socket can be used straight away
+	for i := 0; i < 20; i++ {
+		if _, err := os.Stat(socket); err == nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	fmt.Printf("%s=%s; export %s;\n", client.AgentSocketEnvVar, socket, client.AgentSocketEnvVar)
+	fmt.Println("echo Agent pid " + strconv.Itoa(cmd.Process.Pid) + ";")
+
+	return nil
+}
+
+// runAgent serves Data Keys on the given socket until the agent is stopped. If tempDir is set, the directory of
+// the socket was created just for the agent, and is removed on shutdown.
+func runAgent(socket string, ttl time.Duration, tempDir bool) error {
+	if tempDir {
+		defer os.Remove(filepath.Dir(socket))
+	}
+
+	// Only the current user should be able to talk to the agent
+	l, err := listenPrivate(socket)
+	if err != nil {
+		return fmt.Errorf("%w; Failed to listen on agent socket", err)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-signals
+		l.Close() // This also removes the socket file
+	}()
+
+	fmt.Println("Agent listening on: " + socket)
+
+	// Serve only returns once the listener is closed, which is also how we shut down
+	if err := client.NewAgent(ttl).Serve(l); !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+
+	return nil
+}
+
+func agentFromEnv() (*client.AgentClient, error) {
+	socket := os.Getenv(client.AgentSocketEnvVar)
+	if socket == "" {
+		return nil, errors.New(client.AgentSocketEnvVar + " is not set; is the agent running?")
+	}
+
+	return client.NewAgentClient(socket), nil
+}
+
+func cmdAgentLock(c *cli.Context) error {
+	agent, err := agentFromEnv()
+	if err != nil {
+		return err
+	}
+
+	password, err := promptSilent("Agent password:")
+	if err != nil {
+		return err
+	}
+
+	confirmed, err := promptSilent("Confirm agent password:")
+	if err != nil {
+		return err
+	}
+
+	if string(password) != string(confirmed) {
+		return errors.New("passwords did not match")
+	}
+
+	return agent.Lock(password)
+}
+
+func cmdAgentUnlock(c *cli.Context) error {
+	agent, err := agentFromEnv()
+	if err != nil {
+		return err
+	}
+
+	password, err := promptSilent("Agent password:")
+	if err != nil {
+		return err
+	}
+
+	return agent.Unlock(password)
+}
@@ -0,0 +1,25 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"net"
+	"os/exec"
+	"syscall"
+)
+
+// listenPrivate listens on a Unix socket which is only accessible to the current user. The umask is set
+// before the socket is created (rather than changing its permissions afterwards), so that there's no window
+// during which someone else could connect.
+func listenPrivate(socket string) (net.Listener, error) {
+	old := syscall.Umask(0077)
+	defer syscall.Umask(old)
+
+	return net.Listen("unix", socket)
+}
+
+// detachProcess starts the given command in a new session, without a controlling terminal
+func detachProcess(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+}
@@ -0,0 +1,24 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"golang.org/x/sys/windows"
+	"net"
+	"os/exec"
+	"syscall"
+)
+
+// listenPrivate listens on a Unix socket. There's no umask on Windows; access to the socket is restricted
+// by the permissions of the directory it's in instead.
+func listenPrivate(socket string) (net.Listener, error) {
+	return net.Listen("unix", socket)
+}
+
+// detachProcess starts the given command without a console, in its own process group
+func detachProcess(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		CreationFlags: windows.CREATE_NEW_PROCESS_GROUP | windows.DETACHED_PROCESS,
+	}
+}
@@ -54,6 +54,7 @@ func EnvKeysAutocomplete(c *cli.Context, basedir string, envStr string) {
 }
 
 func ConfigstoreKeysAutocomplete(c *cli.Context) {
+	// Opened without the client options from OpenConfigstore on purpose: completion must never prompt for anything
 	cc, err := client.NewConfigstoreClient(c.String("db"), []string{}, true)
 	if err != nil {
 		return
@@ -2,7 +2,6 @@ package main
 
 import (
 	"errors"
-	"github.com/motns/configstore/client"
 	"gopkg.in/urfave/cli.v1"
 )
 
@@ -17,12 +16,12 @@ func cmdCompareKeys(c *cli.Context) error {
 		return errors.New("you have to provide a Configstore DB to compare against as the second argument")
 	}
 
-	cc1, err := client.NewConfigstoreClient(dbFile1, make([]string, 0), c.Bool("ignore-role"))
+	cc1, err := OpenConfigstore(dbFile1, make([]string, 0), c.Bool("ignore-role"))
 	if err != nil {
 		return err
 	}
 
-	cc2, err := client.NewConfigstoreClient(dbFile2, make([]string, 0), c.Bool("ignore-role"))
+	cc2, err := OpenConfigstore(dbFile2, make([]string, 0), c.Bool("ignore-role"))
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/motns/configstore/client"
+	"gopkg.in/urfave/cli.v1"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func cmdConvert(c *cli.Context) error {
+	dbFile := c.String("db")
+
+	if to := c.String("to"); to != "" {
+		if c.String("format") != "" {
+			return errors.New("--format cannot be used together with --to; the format is picked based on the extension of the destination")
+		}
+
+		if err := client.ConvertDB(dbFile, to); err != nil {
+			return err
+		}
+
+		fmt.Printf("Copied Configstore DB to: %s\n", to)
+		return nil
+	}
+
+	if client.IsRemoteLocation(dbFile) {
+		return errors.New("Configstore DBs in remote storage can only be copied, via --to")
+	}
+
+	return convertDBFile(dbFile, c.String("format"), c.Bool("keep"))
+}
+
+func cmdPackageConvert(c *cli.Context) error {
+	basedir := c.String("basedir")
+	envStr := c.Args().Get(0)
+
+	var envs = make([]Env, 0)
+
+	if envStr != "" {
+		env, err := ParseEnv(envStr, basedir, true)
+		if err != nil {
+			return err
+		}
+
+		if env.isSubenv() {
+			return errors.New("convert command not supported for sub-environment")
+		}
+
+		envs = append(envs, env)
+	} else {
+		dirs, err := ListDirs(basedir + "/env")
+		if err != nil {
+			return err
+		}
+
+		for _, d := range dirs {
+			envs = append(envs, Env{
+				basedir:     basedir,
+				envName:     d,
+				subenvNames: nil,
+			})
+		}
+	}
+
+	for _, env := range envs {
+		if err := convertDBFile(env.dbFile(), c.String("format"), c.Bool("keep")); err != nil {
+			return fmt.Errorf("%w; Failed to convert env: %s", err, env.envStr())
+		}
+	}
+
+	return nil
+}
+
+// convertDBFile writes the given DB file in the given format, next to the original (which is removed, unless keep
+// is set)
+func convertDBFile(dbFile string, format string, keep bool) error {
+	if format != client.FormatJSON && format != client.FormatYAML && format != client.FormatTOML {
+		return fmt.Errorf("unsupported format: %s (available: %s, %s, %s)", format, client.FormatJSON, client.FormatYAML, client.FormatTOML)
+	}
+
+	if client.FormatForFile(dbFile) == format {
+		fmt.Printf("Configstore DB is already stored as %s: %s\n", format, dbFile)
+		return nil
+	}
+
+	dstFile := strings.TrimSuffix(dbFile, filepath.Ext(dbFile)) + "." + format
+
+	if err := client.ConvertDB(dbFile, dstFile); err != nil {
+		return err
+	}
+
+	fmt.Printf("Converted Configstore DB to %s: %s\n", format, dstFile)
+
+	if keep {
+		return nil
+	}
+
+	if err := os.Remove(dbFile); err != nil {
+		return fmt.Errorf("%w; Failed to remove original DB file: %s", err, dbFile)
+	}
+
+	return nil
+}
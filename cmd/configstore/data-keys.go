@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/motns/configstore/client"
+	"gopkg.in/urfave/cli.v1"
+	"strconv"
+	"strings"
+)
+
+func cmdDataKeys(c *cli.Context) error {
+	cc, err := OpenConfigstore(c.String("db"), make([]string, 0), false)
+	if err != nil {
+		return err
+	}
+
+	for i, w := range cc.DataKeyWrappings() {
+		fmt.Printf("[%d] %s\n", i, describeDataKey(w))
+	}
+
+	return nil
+}
+
+func cmdAddDataKey(c *cli.Context) error {
+	masterKey := c.String("master-key")
+	usePassphrase := c.Bool("passphrase")
+	ageRecipients := c.StringSlice("age-recipient")
+
+	options := 0
+	for _, set := range []bool{masterKey != "", usePassphrase, len(ageRecipients) > 0} {
+		if set {
+			options++
+		}
+	}
+
+	if options != 1 {
+		return errors.New("you have to specify exactly one of --master-key, --passphrase or --age-recipient")
+	}
+
+	cc, err := OpenConfigstore(c.String("db"), make([]string, 0), c.Bool("ignore-role"))
+	if err != nil {
+		return err
+	}
+
+	if masterKey != "" {
+		return cc.AddKMSDataKey(masterKey, c.String("region"), c.String("role"))
+	}
+
+	if usePassphrase {
+		fmt.Println("Enter the passphrase for the new copy of the Data Key")
+
+		passphrase, err := ReadNewPassphrase()
+		if err != nil {
+			return err
+		}
+
+		return cc.AddPassphraseDataKey(passphrase)
+	}
+
+	return cc.AddAgeDataKey(ageRecipients)
+}
+
+func cmdRemoveDataKey(c *cli.Context) error {
+	index, err := strconv.Atoi(c.Args().Get(0))
+	if err != nil {
+		return errors.New("you have to specify the index of the Data Key copy to remove as the first argument (see `data_keys`)")
+	}
+
+	cc, err := OpenConfigstore(c.String("db"), make([]string, 0), false)
+	if err != nil {
+		return err
+	}
+
+	return cc.RemoveDataKey(index)
+}
+
+func describeDataKey(w client.DataKeyWrapping) string {
+	switch w.KeyProvider {
+	case client.KMSKeyProviderName:
+		out := fmt.Sprintf("%s: %s (%s)", w.KeyProvider, w.MasterKeyId, w.Region)
+		if w.Role != "" {
+			out += " via IAM Role " + w.Role
+		}
+		return out
+	case client.AgeKeyProviderName:
+		return w.KeyProvider + ": " + strings.Join(w.AgeRecipients, ", ")
+	default:
+		return w.KeyProvider
+	}
+}
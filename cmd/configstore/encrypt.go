@@ -1,12 +1,11 @@
 package main
 
 import (
-	"github.com/motns/configstore/client"
 	"gopkg.in/urfave/cli.v1"
 )
 
 func cmdEncrypt(c *cli.Context) error {
-	cc, err := client.NewConfigstoreClient(c.String("db"), c.StringSlice("override"), c.Bool("ignore-role"))
+	cc, err := OpenConfigstore(c.String("db"), c.StringSlice("override"), c.Bool("ignore-role"))
 	if err != nil {
 		return err
 	}
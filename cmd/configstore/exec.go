@@ -2,14 +2,13 @@ package main
 
 import (
 	"fmt"
-	"github.com/motns/configstore/client"
 	"gopkg.in/urfave/cli.v1"
 	"os/exec"
 	"strings"
 )
 
 func cmdExec(c *cli.Context) error {
-	cc, err := client.NewConfigstoreClient(c.String("db"), c.StringSlice("override"), c.Bool("ignore-role"))
+	cc, err := OpenConfigstore(c.String("db"), c.StringSlice("override"), c.Bool("ignore-role"))
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/motns/configstore/client"
+	"gopkg.in/urfave/cli.v1"
+)
+
+func generatorOptionsFromFlags(c *cli.Context) client.GeneratorOptions {
+	return client.GeneratorOptions{
+		Length:  c.Int("length"),
+		Charset: c.String("charset"),
+		Format:  c.String("format"),
+	}
+}
+
+func cmdGenerate(c *cli.Context) error {
+	key := c.Args().Get(0)
+	generator := c.Args().Get(1)
+
+	if key == "" || generator == "" {
+		return errors.New("you have to specify a key and a generator")
+	}
+
+	cc, err := OpenConfigstore(c.String("db"), make([]string, 0), c.Bool("ignore-role"))
+	if err != nil {
+		return err
+	}
+
+	return cc.Generate(key, generator, generatorOptionsFromFlags(c), c.Bool("force"))
+}
+
+func cmdPackageGenerate(c *cli.Context) error {
+	basedir := c.String("basedir")
+	key := c.Args().Get(0)
+	generator := c.Args().Get(1)
+	ignoreRole := c.Bool("ignore-role")
+
+	if key == "" || generator == "" {
+		return errors.New("you have to specify a key and a generator")
+	}
+
+	var envs = make([]Env, 0)
+
+	if envStrs := c.StringSlice("env"); len(envStrs) > 0 {
+		for _, envStr := range envStrs {
+			env, err := ParseEnv(envStr, basedir, true)
+			if err != nil {
+				return err
+			}
+
+			if env.isSubenv() {
+				return errors.New("secret values cannot be stored in overrides")
+			}
+
+			if !env.envExists() {
+				return errors.New("env doesn't exist: " + env.envStr())
+			}
+
+			envs = append(envs, env)
+		}
+	} else {
+		dirs, err := ListDirs(basedir + "/env")
+		if err != nil {
+			return err
+		}
+
+		for _, d := range dirs {
+			envs = append(envs, Env{
+				basedir:     basedir,
+				envName:     d,
+				subenvNames: nil,
+			})
+		}
+	}
+
+	for _, env := range envs {
+		fmt.Println("Generating " + key + " for env: " + env.envStr())
+
+		cc, err := ConfigstoreForEnv(env, ignoreRole)
+		if err != nil {
+			return err
+		}
+
+		// Each environment gets its own value
+		if err := cc.Generate(key, generator, generatorOptionsFromFlags(c), c.Bool("force")); err != nil {
+			return fmt.Errorf("%w; Failed to generate value for env: %s", err, env.envStr())
+		}
+	}
+
+	return nil
+}
@@ -2,12 +2,11 @@ package main
 
 import (
 	"fmt"
-	"github.com/motns/configstore/client"
 	"gopkg.in/urfave/cli.v1"
 )
 
 func cmdGet(c *cli.Context) error {
-	cc, err := client.NewConfigstoreClient(c.String("db"), c.StringSlice("override"), c.Bool("ignore-role"))
+	cc, err := OpenConfigstore(c.String("db"), c.StringSlice("override"), c.Bool("ignore-role"))
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/urfave/cli.v1"
+	"strconv"
+	"time"
+)
+
+func cmdHistory(c *cli.Context) error {
+	cc, err := OpenConfigstore(c.String("db"), make([]string, 0), c.Bool("ignore-role"))
+	if err != nil {
+		return err
+	}
+
+	key := c.Args().Get(0)
+
+	history, err := cc.History(key, c.Bool("skip-decryption"))
+	if err != nil {
+		return err
+	}
+
+	if len(history) == 0 {
+		fmt.Println("No previous values for key: " + key)
+		return nil
+	}
+
+	for i, h := range history {
+		value := h.Value
+		if h.IsBinary {
+			value = "(binary)"
+		}
+
+		fmt.Println(strconv.Itoa(i+1) + ": " + value)
+
+		if h.SetAt != nil {
+			fmt.Println("  set: " + h.SetAt.Format(time.RFC3339))
+		}
+
+		fmt.Println("  replaced: " + h.ReplacedAt.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+func cmdRollback(c *cli.Context) error {
+	key := c.Args().Get(0)
+	n := 1
+
+	if nStr := c.Args().Get(1); nStr != "" {
+		parsed, err := strconv.Atoi(nStr)
+		if err != nil {
+			return errors.New("the previous value to roll back to has to be a number: " + nStr)
+		}
+
+		n = parsed
+	}
+
+	cc, err := OpenConfigstore(c.String("db"), make([]string, 0), c.Bool("ignore-role"))
+	if err != nil {
+		return err
+	}
+
+	return cc.Rollback(key, n)
+}
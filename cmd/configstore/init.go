@@ -13,20 +13,59 @@ func cmdInit(c *cli.Context) error {
 	role := c.String("role")
 	masterKey := c.String("master-key")
 	isInsecure := c.Bool("insecure")
+	usePassphrase := c.Bool("passphrase")
+	ageRecipients := c.StringSlice("age-recipient")
+
+	encryptionContext, err := ParseEncryptionContext(c.StringSlice("encryption-context"))
+	if err != nil {
+		return err
+	}
 
 	// Check that destination folder exists
 	if _, err := os.Stat(dir); err != nil {
 		return err
 	}
 
-	if !isInsecure && masterKey == "" {
-		return errors.New("you have to specify --master-key if --insecure is not set")
+	if encryptionContext != nil && (isInsecure || usePassphrase || len(ageRecipients) > 0) {
+		return errors.New("--encryption-context can only be used with --master-key")
 	}
 
-	_, err := client.InitConfigstore(dir, region, role, masterKey, isInsecure)
-	if err != nil {
+	if AWSSettingsFromFlags(c) != nil && (isInsecure || usePassphrase || len(ageRecipients) > 0) {
+		return errors.New("AWS settings can only be used with --master-key")
+	}
+
+	if usePassphrase {
+		if isInsecure || masterKey != "" || len(ageRecipients) > 0 {
+			return errors.New("--passphrase cannot be combined with --insecure, --master-key or --age-recipient")
+		}
+
+		passphrase, err := ReadNewPassphrase()
+		if err != nil {
+			return err
+		}
+
+		_, err = client.InitPassphraseConfigstore(dir, passphrase)
 		return err
 	}
 
-	return nil
+	if len(ageRecipients) > 0 {
+		if isInsecure || masterKey != "" {
+			return errors.New("--age-recipient cannot be combined with --insecure or --master-key")
+		}
+
+		_, err = client.InitAgeConfigstore(dir, ageRecipients)
+		return err
+	}
+
+	if !isInsecure && masterKey == "" {
+		return errors.New("you have to specify --master-key if --insecure, --passphrase or --age-recipient is not set")
+	}
+
+	if isInsecure {
+		_, err = client.InitConfigstore(dir, region, role, masterKey, true)
+	} else {
+		_, err = client.InitKMSConfigstore(dir, region, role, masterKey, encryptionContext, AWSSettingsFromFlags(c), ClientOptions()...)
+	}
+
+	return err
 }
@@ -2,13 +2,12 @@ package main
 
 import (
 	"fmt"
-	"github.com/motns/configstore/client"
 	"gopkg.in/urfave/cli.v1"
 	"sort"
 )
 
 func cmdLs(c *cli.Context) error {
-	cc, err := client.NewConfigstoreClient(c.String("db"), c.StringSlice("override"), c.Bool("ignore-role"))
+	cc, err := OpenConfigstore(c.String("db"), c.StringSlice("override"), c.Bool("ignore-role"))
 	if err != nil {
 		return err
 	}
@@ -21,14 +20,26 @@ func cmdLs(c *cli.Context) error {
 	allKeys := cc.GetAllKeys(c.Args().Get(0))
 	sort.Strings(allKeys)
 
+	tags := c.StringSlice("tag")
+
 	for _, k := range allKeys {
 		e := entries[k]
 
+		if !e.HasTags(tags) {
+			continue
+		}
+
 		if e.IsBinary {
 			fmt.Println(k + ": (binary)")
 		} else {
 			fmt.Println(k + ": " + e.Value)
 		}
+
+		if c.Bool("meta") {
+			for _, l := range MetadataLines(e) {
+				fmt.Println("  " + l)
+			}
+		}
 	}
 
 	return nil
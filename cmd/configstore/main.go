@@ -1,8 +1,10 @@
 package main
 
 import (
+	"github.com/motns/configstore/client"
 	"gopkg.in/urfave/cli.v1"
 	"os"
+	"time"
 )
 
 func main() {
@@ -14,6 +16,49 @@ func main() {
 	app.EnableBashCompletion = true
 	app.Version = "2.6.0"
 
+	// Commands work on whichever Configstore DB is in the current directory by default, in any storage format
+	defaultDB := client.FindDBFile(".")
+
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:  "kms-endpoint",
+			Usage: "Custom endpoint for AWS KMS (for a VPC endpoint or a local KMS emulator for example); overrides the setting stored in the Configstore DB",
+		},
+		cli.StringFlag{
+			Name:  "aws-profile",
+			Usage: "Named AWS profile to load credentials from; overrides the setting stored in the Configstore DB",
+		},
+		cli.StringFlag{
+			Name:  "external-id",
+			Usage: "External ID to use when assuming the IAM Role; overrides the setting stored in the Configstore DB",
+		},
+		cli.StringFlag{
+			Name:  "role-session-name",
+			Usage: "Session name to use when assuming the IAM Role; overrides the setting stored in the Configstore DB",
+		},
+		cli.StringFlag{
+			Name:  "mfa-serial",
+			Usage: "Serial number (or ARN) of the MFA device required for assuming the IAM Role; overrides the setting stored in the Configstore DB",
+		},
+		cli.BoolFlag{
+			Name:   "require-integrity",
+			Usage:  "Refuse to load Configstore DBs which aren't protected by an integrity tag, or which fail verification",
+			EnvVar: "CONFIGSTORE_REQUIRE_INTEGRITY",
+		},
+	}
+
+	app.Before = func(c *cli.Context) error {
+		awsOverrides = client.AWSSettings{
+			Endpoint:    c.GlobalString("kms-endpoint"),
+			Profile:     c.GlobalString("aws-profile"),
+			ExternalId:  c.GlobalString("external-id"),
+			SessionName: c.GlobalString("role-session-name"),
+			MFASerial:   c.GlobalString("mfa-serial"),
+		}
+		requireIntegrity = c.GlobalBool("require-integrity")
+		return nil
+	}
+
 	app.Commands = []cli.Command{
 		{
 			Name:   "init",
@@ -42,6 +87,38 @@ func main() {
 					Name:  "insecure",
 					Usage: "Initialise this Configstore with a plain-text encryption key (not backed by KMS)",
 				},
+				cli.BoolFlag{
+					Name:  "passphrase",
+					Usage: "Initialise this Configstore with an encryption key protected by a passphrase (not backed by KMS)",
+				},
+				cli.StringSliceFlag{
+					Name:  "age-recipient",
+					Usage: "Initialise this Configstore with an encryption key encrypted for the given age public key (not backed by KMS); can be passed multiple times",
+				},
+				cli.StringSliceFlag{
+					Name:  "encryption-context",
+					Usage: "A key=value pair to use as KMS encryption context for this Configstore; can be passed multiple times",
+				},
+				cli.StringFlag{
+					Name:  "kms-endpoint",
+					Usage: "Custom endpoint for AWS KMS (for a VPC endpoint or a local KMS emulator for example); stored in the Configstore DB",
+				},
+				cli.StringFlag{
+					Name:  "aws-profile",
+					Usage: "Named AWS profile to load credentials from; stored in the Configstore DB",
+				},
+				cli.StringFlag{
+					Name:  "external-id",
+					Usage: "External ID to use when assuming the IAM Role; stored in the Configstore DB",
+				},
+				cli.StringFlag{
+					Name:  "role-session-name",
+					Usage: "Session name to use when assuming the IAM Role; stored in the Configstore DB",
+				},
+				cli.StringFlag{
+					Name:  "mfa-serial",
+					Usage: "Serial number (or ARN) of the MFA device required for assuming the IAM Role; stored in the Configstore DB",
+				},
 			},
 		},
 		{
@@ -52,8 +129,8 @@ func main() {
 			Flags: []cli.Flag{
 				cli.StringFlag{
 					Name:  "db",
-					Usage: "The Configstore JSON file",
-					Value: "./configstore.json",
+					Usage: "The Configstore DB file",
+					Value: defaultDB,
 				},
 				cli.BoolFlag{
 					Name:  "secret",
@@ -63,6 +140,14 @@ func main() {
 					Name:  "binary",
 					Usage: "Indicate whether this value contains binary data (instead of plain text)",
 				},
+				cli.StringFlag{
+					Name:  "from-file",
+					Usage: "Set every key/value pair from a JSON file with a single, flat object in it (or StdIn if \"-\"), writing the DB only once",
+				},
+				cli.StringFlag{
+					Name:  "type",
+					Usage: "Declare the type of the value, which is then validated: string, int, float, bool, duration, url, json or list",
+				},
 				cli.BoolFlag{
 					Name:  "ignore-role",
 					Usage: "Do not assume the IAM Role for this Configstore (if one was set) before calling the AWS API",
@@ -77,8 +162,8 @@ func main() {
 			Flags: []cli.Flag{
 				cli.StringFlag{
 					Name:  "db",
-					Usage: "The Configstore JSON file",
-					Value: "./configstore.json",
+					Usage: "The Configstore DB file",
+					Value: defaultDB,
 				},
 				cli.StringSliceFlag{
 					Name:  "override",
@@ -91,6 +176,46 @@ func main() {
 			},
 			BashComplete: ConfigstoreKeysAutocomplete,
 		},
+		{
+			Name:      "history",
+			Usage:     "List the previous values of a key, starting with the most recent one",
+			ArgsUsage: "key",
+			Action:    cmdHistory,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "db",
+					Usage: "The Configstore DB file",
+					Value: defaultDB,
+				},
+				cli.BoolFlag{
+					Name:  "ignore-role",
+					Usage: "Do not assume the IAM Role for this Configstore (if one was set) before calling the AWS API",
+				},
+				cli.BoolFlag{
+					Name:  "skip-decryption",
+					Usage: "Do not decrypt any secrets - these are replaced by \"(secret)\"",
+				},
+			},
+			BashComplete: ConfigstoreKeysAutocomplete,
+		},
+		{
+			Name:      "rollback",
+			Usage:     "Restore a previous value of a key (the most recent one by default, or the one numbered n in the output of history)",
+			ArgsUsage: "key [n]",
+			Action:    cmdRollback,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "db",
+					Usage: "The Configstore DB file",
+					Value: defaultDB,
+				},
+				cli.BoolFlag{
+					Name:  "ignore-role",
+					Usage: "Do not assume the IAM Role for this Configstore (if one was set) before calling the AWS API",
+				},
+			},
+			BashComplete: ConfigstoreKeysAutocomplete,
+		},
 		{
 			Name:      "encrypt",
 			Usage:     "Take an existing value from the DB and change it to be a secret (encrypted) value",
@@ -99,8 +224,8 @@ func main() {
 			Flags: []cli.Flag{
 				cli.StringFlag{
 					Name:  "db",
-					Usage: "The Configstore JSON file",
-					Value: "./configstore.json",
+					Usage: "The Configstore DB file",
+					Value: defaultDB,
 				},
 				cli.BoolFlag{
 					Name:  "ignore-role",
@@ -117,8 +242,8 @@ func main() {
 			Flags: []cli.Flag{
 				cli.StringFlag{
 					Name:  "db",
-					Usage: "The Configstore JSON file",
-					Value: "./configstore.json",
+					Usage: "The Configstore DB file",
+					Value: defaultDB,
 				},
 				cli.BoolFlag{
 					Name:  "ignore-role",
@@ -135,8 +260,8 @@ func main() {
 			Flags: []cli.Flag{
 				cli.StringFlag{
 					Name:  "db",
-					Usage: "The Configstore JSON file",
-					Value: "./configstore.json",
+					Usage: "The Configstore DB file",
+					Value: defaultDB,
 				},
 				cli.StringSliceFlag{
 					Name:  "override",
@@ -157,8 +282,8 @@ func main() {
 			Flags: []cli.Flag{
 				cli.StringFlag{
 					Name:  "db",
-					Usage: "The Configstore JSON file",
-					Value: "./configstore.json",
+					Usage: "The Configstore DB file",
+					Value: defaultDB,
 				},
 				cli.StringSliceFlag{
 					Name:  "override",
@@ -172,6 +297,14 @@ func main() {
 					Name:  "skip-decryption",
 					Usage: "Do not decrypt any secrets - these are replaced by \"(string)\"",
 				},
+				cli.BoolFlag{
+					Name:  "meta",
+					Usage: "Also print the type and metadata (description, owner, tags and timestamps) of each key",
+				},
+				cli.StringSliceFlag{
+					Name:  "tag",
+					Usage: "Only show keys with this tag (can be repeated, in which case keys need to have all of the tags)",
+				},
 			},
 		},
 		{
@@ -182,12 +315,383 @@ func main() {
 			Flags: []cli.Flag{
 				cli.StringFlag{
 					Name:  "db",
-					Usage: "The Configstore JSON file",
-					Value: "./configstore.json",
+					Usage: "The Configstore DB file",
+					Value: defaultDB,
+				},
+			},
+			BashComplete: ConfigstoreKeysAutocomplete,
+		},
+		{
+			Name:      "set_meta",
+			Usage:     "Set the description, owner and tags of a key in the Configstore; only the fields passed are changed",
+			ArgsUsage: "key",
+			Action:    cmdSetMeta,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "db",
+					Usage: "The Configstore DB file",
+					Value: defaultDB,
+				},
+				cli.StringFlag{
+					Name:  "description",
+					Usage: "What the key is for",
+				},
+				cli.StringFlag{
+					Name:  "owner",
+					Usage: "Who is responsible for the key",
+				},
+				cli.StringSliceFlag{
+					Name:  "tag",
+					Usage: "Add a tag to the key (can be repeated)",
+				},
+				cli.StringSliceFlag{
+					Name:  "remove-tag",
+					Usage: "Remove a tag from the key (can be repeated)",
+				},
+				cli.BoolFlag{
+					Name:  "clear-tags",
+					Usage: "Remove all existing tags from the key, before adding the ones passed via --tag",
+				},
+				cli.BoolFlag{
+					Name:  "ignore-role",
+					Usage: "Do not assume the IAM Role for this Configstore (if one was set) before calling the AWS API",
 				},
 			},
 			BashComplete: ConfigstoreKeysAutocomplete,
 		},
+		{
+			Name:   "rotate_data_key",
+			Usage:  "Generate a new Data Key for the Configstore, and re-encrypt all secrets with it",
+			Action: cmdRotateDataKey,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "db",
+					Usage: "The Configstore DB file",
+					Value: defaultDB,
+				},
+				cli.BoolFlag{
+					Name:  "ignore-role",
+					Usage: "Do not assume the IAM Role for this Configstore (if one was set) before calling the AWS API",
+				},
+			},
+		},
+		{
+			Name:   "rewrap",
+			Usage:  "Re-encrypt the Data Key of the Configstore with a different KMS Master Key, leaving secret values untouched",
+			Action: cmdRewrap,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "db",
+					Usage: "The Configstore DB file",
+					Value: defaultDB,
+				},
+				cli.StringFlag{
+					Name:  "master-key",
+					Usage: "The name of the AWS KMS key to re-encrypt the Data Key with",
+				},
+				cli.StringFlag{
+					Name:  "region",
+					Usage: "The AWS Region of the new KMS key (defaults to the current Region)",
+				},
+				cli.StringFlag{
+					Name:  "role",
+					Usage: "The IAM Role to assume before executing AWS API operations with the new KMS key (defaults to the current Role)",
+				},
+				cli.BoolFlag{
+					Name:  "ignore-role",
+					Usage: "Do not assume the current IAM Role for this Configstore (if one was set) when decrypting the Data Key",
+				},
+			},
+		},
+		{
+			Name:      "add_recipient",
+			Usage:     "Give the holder of a matching age identity access to an age-backed Configstore, by re-encrypting the Data Key for them",
+			ArgsUsage: "age_public_key",
+			Action:    cmdAddRecipient,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "db",
+					Usage: "The Configstore DB file",
+					Value: defaultDB,
+				},
+			},
+		},
+		{
+			Name:      "remove_recipient",
+			Usage:     "Remove an age recipient from an age-backed Configstore, by re-encrypting the Data Key without them",
+			ArgsUsage: "age_public_key",
+			Action:    cmdRemoveRecipient,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "db",
+					Usage: "The Configstore DB file",
+					Value: defaultDB,
+				},
+			},
+		},
+		{
+			Name:   "data_keys",
+			Usage:  "List every copy of the Data Key held in the Configstore, in the order they are tried when decrypting",
+			Action: cmdDataKeys,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "db",
+					Usage: "The Configstore DB file",
+					Value: defaultDB,
+				},
+			},
+		},
+		{
+			Name:   "add_data_key",
+			Usage:  "Add a copy of the Data Key protected by a different KMS Master Key, passphrase or set of age recipients",
+			Action: cmdAddDataKey,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "db",
+					Usage: "The Configstore DB file",
+					Value: defaultDB,
+				},
+				cli.StringFlag{
+					Name:  "master-key",
+					Usage: "The name of the AWS KMS key to wrap the new copy of the Data Key with",
+				},
+				cli.StringFlag{
+					Name:  "region",
+					Usage: "The AWS Region of the KMS key",
+					Value: "eu-west-1",
+				},
+				cli.StringFlag{
+					Name:  "role",
+					Usage: "The IAM Role to assume before executing AWS API operations with the KMS key",
+				},
+				cli.BoolFlag{
+					Name:  "passphrase",
+					Usage: "Protect the new copy of the Data Key with a passphrase",
+				},
+				cli.StringSliceFlag{
+					Name:  "age-recipient",
+					Usage: "Encrypt the new copy of the Data Key for the given age public key; can be passed multiple times",
+				},
+				cli.BoolFlag{
+					Name:  "ignore-role",
+					Usage: "Do not assume the IAM Role for this Configstore (if one was set) when decrypting the Data Key",
+				},
+			},
+		},
+		{
+			Name:      "remove_data_key",
+			Usage:     "Remove a copy of the Data Key from the Configstore, by its index (as listed by `data_keys`)",
+			ArgsUsage: "index",
+			Action:    cmdRemoveDataKey,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "db",
+					Usage: "The Configstore DB file",
+					Value: defaultDB,
+				},
+			},
+		},
+		{
+			Name:   "secure",
+			Usage:  "Convert an insecure Configstore into one backed by a KMS Master Key, re-encrypting all secrets",
+			Action: cmdSecure,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "db",
+					Usage: "The Configstore DB file",
+					Value: defaultDB,
+				},
+				cli.StringFlag{
+					Name:  "region",
+					Usage: "The AWS Region the KMS key was created in",
+					Value: "eu-west-1",
+				},
+				cli.StringFlag{
+					Name:  "role",
+					Usage: "The IAM Role to assume before executing AWS API operations",
+				},
+				cli.StringFlag{
+					Name:  "master-key",
+					Usage: "The name of the AWS KMS key to be used as the master encryption key",
+				},
+			},
+		},
+		{
+			Name:   "insecure",
+			Usage:  "Convert a Configstore into an insecure one with a plain-text encryption key, re-encrypting all secrets (for local Dev copies only)",
+			Action: cmdInsecure,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "db",
+					Usage: "The Configstore DB file",
+					Value: defaultDB,
+				},
+				cli.BoolFlag{
+					Name:  "ignore-role",
+					Usage: "Do not assume the IAM Role for this Configstore (if one was set) before calling the AWS API",
+				},
+			},
+		},
+		{
+			Name:      "generate",
+			Usage:     "Generate a random value, and store it as a secret. Generators: password, random, uuid, rsa, ed25519",
+			ArgsUsage: "key generator",
+			Action:    cmdGenerate,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "db",
+					Usage: "The Configstore DB file",
+					Value: defaultDB,
+				},
+				cli.IntFlag{
+					Name:  "length",
+					Usage: "The length of the value: characters for password, bytes for random, bits for rsa (defaults depend on the generator)",
+				},
+				cli.StringFlag{
+					Name:  "charset",
+					Usage: "The characters to use for password: alpha, alnum, numeric, hex or symbols (default: alnum)",
+				},
+				cli.StringFlag{
+					Name:  "format",
+					Usage: "The output format: hex, base64 or base64url for random (default: hex); pem or openssh for the public key of rsa and ed25519 (default: pem)",
+				},
+				cli.BoolFlag{
+					Name:  "force",
+					Usage: "Overwrite the key if it already exists",
+				},
+				cli.BoolFlag{
+					Name:  "ignore-role",
+					Usage: "Do not assume the IAM Role for this Configstore (if one was set) before calling the AWS API",
+				},
+			},
+		},
+		{
+			Name:   "convert",
+			Usage:  "Convert the Configstore DB file to a different storage format (json, yaml or toml), or copy it to a different location (like S3)",
+			Action: cmdConvert,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "db",
+					Usage: "The Configstore DB file",
+					Value: defaultDB,
+				},
+				cli.StringFlag{
+					Name:  "format",
+					Usage: "The format to convert to: json, yaml or toml",
+				},
+				cli.BoolFlag{
+					Name:  "keep",
+					Usage: "Keep the original DB file (it's removed by default)",
+				},
+				cli.StringFlag{
+					Name:  "to",
+					Usage: "Copy the DB to the given location instead (a local path, or s3://bucket/key), in the format matching its extension",
+				},
+			},
+		},
+		{
+			Name:   "migrate",
+			Usage:  "Upgrade the Configstore DB file to the latest version (older versions are only upgraded in memory when loaded)",
+			Action: cmdMigrate,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "db",
+					Usage: "The Configstore DB file",
+					Value: defaultDB,
+				},
+				cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "Only list the migrations which would be applied, without writing the DB file",
+				},
+				cli.BoolFlag{
+					Name:  "ignore-role",
+					Usage: "Do not assume the IAM Role for this Configstore (if one was set) before calling the AWS API",
+				},
+			},
+		},
+		{
+			Name:   "verify",
+			Usage:  "Check the integrity tag of the Configstore, to make sure that it hasn't been modified outside of Configstore",
+			Action: cmdVerify,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "db",
+					Usage: "The Configstore DB file",
+					Value: defaultDB,
+				},
+				cli.BoolFlag{
+					Name:  "ignore-role",
+					Usage: "Do not assume the IAM Role for this Configstore (if one was set) before calling the AWS API",
+				},
+			},
+		},
+		{
+			Name:   "enable_integrity",
+			Usage:  "Protect the Configstore with an integrity tag, which is checked and recomputed whenever it's saved",
+			Action: cmdEnableIntegrity,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "db",
+					Usage: "The Configstore DB file",
+					Value: defaultDB,
+				},
+				cli.BoolFlag{
+					Name:  "ignore-role",
+					Usage: "Do not assume the IAM Role for this Configstore (if one was set) before calling the AWS API",
+				},
+			},
+		},
+		{
+			Name:   "disable_integrity",
+			Usage:  "Remove the integrity tag from the Configstore",
+			Action: cmdDisableIntegrity,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "db",
+					Usage: "The Configstore DB file",
+					Value: defaultDB,
+				},
+				cli.BoolFlag{
+					Name:  "ignore-role",
+					Usage: "Do not assume the IAM Role for this Configstore (if one was set) before calling the AWS API",
+				},
+			},
+		},
+		{
+			Name:   "agent",
+			Usage:  "Start an agent which caches decrypted Data Keys, so that they don't have to be decrypted via KMS on every call",
+			Action: cmdAgent,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "socket",
+					Usage: "The path of the Unix socket to listen on (defaults to a new temporary directory)",
+				},
+				cli.DurationFlag{
+					Name:  "ttl",
+					Usage: "How long to keep each Data Key for",
+					Value: 15 * time.Minute,
+				},
+				cli.BoolFlag{
+					Name:  "foreground",
+					Usage: "Run the agent in the foreground, instead of starting it in the background",
+				},
+				cli.BoolFlag{
+					Name:   "temp-socket-dir",
+					Usage:  "Remove the directory of the socket on shutdown (set when the directory was created for the agent)",
+					Hidden: true,
+				},
+			},
+		},
+		{
+			Name:   "agent_lock",
+			Usage:  "Lock the agent with a password; cached Data Keys can't be used until it's unlocked",
+			Action: cmdAgentLock,
+		},
+		{
+			Name:   "agent_unlock",
+			Usage:  "Unlock a previously locked agent",
+			Action: cmdAgentUnlock,
+		},
 		{
 			Name:      "process_template",
 			Usage:     "Takes a GO template file, and fills in values from this Configstore",
@@ -196,8 +700,8 @@ func main() {
 			Flags: []cli.Flag{
 				cli.StringFlag{
 					Name:  "db",
-					Usage: "The Configstore JSON file",
-					Value: "./configstore.json",
+					Usage: "The Configstore DB file",
+					Value: defaultDB,
 				},
 				cli.StringSliceFlag{
 					Name:  "override",
@@ -217,8 +721,8 @@ func main() {
 			Flags: []cli.Flag{
 				cli.StringFlag{
 					Name:  "db",
-					Usage: "The Configstore JSON file",
-					Value: "./configstore.json",
+					Usage: "The Configstore DB file",
+					Value: defaultDB,
 				},
 			},
 		},
@@ -228,6 +732,35 @@ func main() {
 			ArgsUsage: "/path/to/database1 /path/to/database2",
 			Action:    cmdCompareKeys,
 		},
+		{
+			Name:      "merge_driver",
+			Aliases:   []string{"merge-driver"},
+			Usage:     "Merge two versions of a Configstore DB or override file key by key (used as a git merge driver)",
+			ArgsUsage: "base current other [path]",
+			Action:    cmdMergeDriver,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "skip-decryption",
+					Usage: "Do not decrypt secrets changed on both sides to compare them - report them as conflicts instead",
+				},
+			},
+		},
+		{
+			Name:   "setup_merge_driver",
+			Usage:  "Register the Configstore merge driver in the git config and .gitattributes of the current repository",
+			Action: cmdSetupMergeDriver,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "command",
+					Usage: "The command git should run to call Configstore",
+					Value: "configstore",
+				},
+				cli.BoolFlag{
+					Name:  "skip-decryption",
+					Usage: "Never decrypt secrets while merging (for machines without access to the Data Key)",
+				},
+			},
+		},
 		{
 			Name:      "exec",
 			Usage:     "Execute a shell command which contains template variables",
@@ -236,8 +769,8 @@ func main() {
 			Flags: []cli.Flag{
 				cli.StringFlag{
 					Name:  "db",
-					Usage: "The Configstore JSON file",
-					Value: "./configstore.json",
+					Usage: "The Configstore DB file",
+					Value: defaultDB,
 				},
 				cli.StringSliceFlag{
 					Name:  "override",
@@ -288,6 +821,38 @@ func main() {
 							Name:  "insecure",
 							Usage: "Initialise this Configstore with a plain-text encryption key (not backed by KMS)",
 						},
+						cli.BoolFlag{
+							Name:  "passphrase",
+							Usage: "Initialise this Configstore with an encryption key protected by a passphrase (not backed by KMS)",
+						},
+						cli.StringSliceFlag{
+							Name:  "age-recipient",
+							Usage: "Initialise this Configstore with an encryption key encrypted for the given age public key (not backed by KMS); can be passed multiple times",
+						},
+						cli.StringSliceFlag{
+							Name:  "encryption-context",
+							Usage: "A key=value pair to use as KMS encryption context for this Configstore; can be passed multiple times",
+						},
+						cli.StringFlag{
+							Name:  "kms-endpoint",
+							Usage: "Custom endpoint for AWS KMS (for a VPC endpoint or a local KMS emulator for example); stored in the Configstore DB",
+						},
+						cli.StringFlag{
+							Name:  "aws-profile",
+							Usage: "Named AWS profile to load credentials from; stored in the Configstore DB",
+						},
+						cli.StringFlag{
+							Name:  "external-id",
+							Usage: "External ID to use when assuming the IAM Role; stored in the Configstore DB",
+						},
+						cli.StringFlag{
+							Name:  "role-session-name",
+							Usage: "Session name to use when assuming the IAM Role; stored in the Configstore DB",
+						},
+						cli.StringFlag{
+							Name:  "mfa-serial",
+							Usage: "Serial number (or ARN) of the MFA device required for assuming the IAM Role; stored in the Configstore DB",
+						},
 					},
 				},
 				{
@@ -335,6 +900,14 @@ func main() {
 							Name:  "binary",
 							Usage: "Indicate whether this value contains binary data (instead of plain text)",
 						},
+						cli.StringFlag{
+							Name:  "from-file",
+							Usage: "Set every key/value pair from a JSON file with a single, flat object in it (or StdIn if \"-\"), writing the DB only once",
+						},
+						cli.StringFlag{
+							Name:  "type",
+							Usage: "Declare the type of the value, which is then validated: string, int, float, bool, duration, url, json or list",
+						},
 						cli.BoolFlag{
 							Name:  "ignore-role",
 							Usage: "Do not assume the IAM Role for this Configstore (if one was set) before calling the AWS API",
@@ -378,6 +951,44 @@ func main() {
 					},
 					BashComplete: PackageCmdAutocomplete(EnvKeysAutocomplete),
 				},
+				{
+					Name:      "set_meta",
+					Usage:     "Set the description, owner and tags of a key in the Configstore of a given environment; only the fields passed are changed",
+					ArgsUsage: "env key",
+					Action:    cmdPackageSetMeta,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "basedir",
+							Usage: "The base directory for the configuration package structure",
+							Value: "./config",
+						},
+						cli.StringFlag{
+							Name:  "description",
+							Usage: "What the key is for",
+						},
+						cli.StringFlag{
+							Name:  "owner",
+							Usage: "Who is responsible for the key",
+						},
+						cli.StringSliceFlag{
+							Name:  "tag",
+							Usage: "Add a tag to the key (can be repeated)",
+						},
+						cli.StringSliceFlag{
+							Name:  "remove-tag",
+							Usage: "Remove a tag from the key (can be repeated)",
+						},
+						cli.BoolFlag{
+							Name:  "clear-tags",
+							Usage: "Remove all existing tags from the key, before adding the ones passed via --tag",
+						},
+						cli.BoolFlag{
+							Name:  "ignore-role",
+							Usage: "Do not assume the IAM Role for this Configstore (if one was set) before calling the AWS API",
+						},
+					},
+					BashComplete: PackageCmdAutocomplete(EnvKeysAutocomplete),
+				},
 				{
 					Name:      "encrypt",
 					Usage:     "Take an existing value from the given environment and change it to be a secret (encrypted) value",
@@ -490,7 +1101,115 @@ func main() {
 							Name:  "skip-decryption",
 							Usage: "Do not decrypt any secrets - these are replaced by \"(string)\"",
 						},
+						cli.BoolFlag{
+							Name:  "meta",
+							Usage: "Also print the type and metadata (description, owner, tags and timestamps) of each key",
+						},
+						cli.StringSliceFlag{
+							Name:  "tag",
+							Usage: "Only show keys with this tag (can be repeated, in which case keys need to have all of the tags)",
+						},
+					},
+				},
+				{
+					Name:      "rotate_data_key",
+					Usage:     "Generate a new Data Key for the given environment (or all environments if none given), and re-encrypt all secrets with it",
+					ArgsUsage: "[env]",
+					Action:    cmdPackageRotateDataKey,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "basedir",
+							Usage: "The base directory for the configuration package structure",
+							Value: "./config",
+						},
+						cli.BoolFlag{
+							Name:  "ignore-role",
+							Usage: "Do not assume the IAM Role for this Configstore (if one was set) before calling the AWS API",
+						},
 					},
+					BashComplete: PackageCmdAutocomplete(nil),
+				},
+				{
+					Name:      "convert",
+					Usage:     "Convert the Configstore DB file of the given environment (or all environments if none given) to a different storage format",
+					ArgsUsage: "[env]",
+					Action:    cmdPackageConvert,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "basedir",
+							Usage: "The base directory for the configuration package structure",
+							Value: "./config",
+						},
+						cli.StringFlag{
+							Name:  "format",
+							Usage: "The format to convert to: json, yaml or toml",
+						},
+						cli.BoolFlag{
+							Name:  "keep",
+							Usage: "Keep the original DB files (they're removed by default)",
+						},
+					},
+					BashComplete: PackageCmdAutocomplete(nil),
+				},
+				{
+					Name:      "migrate",
+					Usage:     "Upgrade the Configstore DB file of the given environment (or all environments if none given) to the latest version",
+					ArgsUsage: "[env]",
+					Action:    cmdPackageMigrate,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "basedir",
+							Usage: "The base directory for the configuration package structure",
+							Value: "./config",
+						},
+						cli.BoolFlag{
+							Name:  "dry-run",
+							Usage: "Only list the migrations which would be applied, without writing any DB files",
+						},
+						cli.BoolFlag{
+							Name:  "ignore-role",
+							Usage: "Do not assume the IAM Role for this Configstore (if one was set) before calling the AWS API",
+						},
+					},
+					BashComplete: PackageCmdAutocomplete(nil),
+				},
+				{
+					Name:      "generate",
+					Usage:     "Generate a random value for the given key, and store it as a secret in every environment (or only the ones given via --env). Generators: password, random, uuid, rsa, ed25519",
+					ArgsUsage: "key generator",
+					Action:    cmdPackageGenerate,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "basedir",
+							Usage: "The base directory for the configuration package structure",
+							Value: "./config",
+						},
+						cli.StringSliceFlag{
+							Name:  "env",
+							Usage: "Only generate a value for this environment (can be repeated)",
+						},
+						cli.IntFlag{
+							Name:  "length",
+							Usage: "The length of the value: characters for password, bytes for random, bits for rsa (defaults depend on the generator)",
+						},
+						cli.StringFlag{
+							Name:  "charset",
+							Usage: "The characters to use for password: alpha, alnum, numeric, hex or symbols (default: alnum)",
+						},
+						cli.StringFlag{
+							Name:  "format",
+							Usage: "The output format: hex, base64 or base64url for random (default: hex); pem or openssh for the public key of rsa and ed25519 (default: pem)",
+						},
+						cli.BoolFlag{
+							Name:  "force",
+							Usage: "Overwrite the key if it already exists",
+						},
+						cli.BoolFlag{
+							Name:  "ignore-role",
+							Usage: "Do not assume the IAM Role for this Configstore (if one was set) before calling the AWS API",
+						},
+					},
+					BashComplete: PackageCmdAutocomplete(nil),
 				},
 				{
 					Name:      "process_templates",
@@ -510,6 +1229,28 @@ func main() {
 					},
 					BashComplete: PackageCmdAutocomplete(nil),
 				},
+				{
+					Name:  "schema",
+					Usage: "Manage the schema declaring the keys in this package, and the rules for their values",
+					Subcommands: []cli.Command{
+						{
+							Name:   "init",
+							Usage:  "Generate a first schema from the Configstore DBs in this package",
+							Action: cmdPackageSchemaInit,
+							Flags: []cli.Flag{
+								cli.StringFlag{
+									Name:  "basedir",
+									Usage: "The base directory for the configuration package structure",
+									Value: "./config",
+								},
+								cli.BoolFlag{
+									Name:  "force",
+									Usage: "Overwrite the schema file if it already exists",
+								},
+							},
+						},
+					},
+				},
 				{
 					Name:   "test",
 					Usage:  "Run checks on the Configstore DBs and templates in this package",
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/motns/configstore/client"
+	"gopkg.in/urfave/cli.v1"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// The name the merge driver is registered under in the git config and .gitattributes
+const mergeDriverName = "configstore"
+
+// The files handled by the merge driver, as .gitattributes patterns
+var mergeDriverPatterns = []string{"configstore.json", "configstore.yaml", "configstore.yml", "configstore.toml", "override.json"}
+
+func cmdMergeDriver(c *cli.Context) error {
+	base := c.Args().Get(0)
+	ours := c.Args().Get(1)
+	theirs := c.Args().Get(2)
+	path := c.Args().Get(3) // Optional; picks the storage format, and is used in messages
+
+	if base == "" || ours == "" || theirs == "" {
+		return errors.New("you have to provide the base, current and other versions of the file (%O %A %B)")
+	}
+
+	if path == "" {
+		path = ours
+	}
+
+	conflicts, err := client.MergeFiles(base, ours, theirs, path, c.Bool("skip-decryption"), ClientOptions()...)
+	if err != nil {
+		return fmt.Errorf("%w; Failed to merge: %s", err, path)
+	}
+
+	if len(conflicts) == 0 {
+		return nil
+	}
+
+	fmt.Printf("Merge conflicts in %s (our version was kept for each):\n", path)
+
+	for _, conflict := range conflicts {
+		if conflict.Key == "" {
+			fmt.Println("  " + conflict.Reason)
+		} else {
+			fmt.Printf("  %s: %s\n", conflict.Key, conflict.Reason)
+		}
+	}
+
+	return fmt.Errorf("failed to merge %d conflicting changes in: %s", len(conflicts), path)
+}
+
+func cmdSetupMergeDriver(c *cli.Context) error {
+	out, err := exec.Command("git", "rev-parse", "--show-toplevel").Output()
+	if err != nil {
+		return fmt.Errorf("%w; Failed to find git repository (are you running this inside one?)", err)
+	}
+
+	root := strings.TrimSpace(string(out))
+
+	driver := c.String("command") + " merge_driver"
+	if c.Bool("skip-decryption") {
+		driver += " --skip-decryption"
+	}
+
+	settings := [][]string{
+		{"merge." + mergeDriverName + ".name", "Key-level merge for Configstore DBs and override files"},
+		{"merge." + mergeDriverName + ".driver", driver + " %O %A %B %P"},
+	}
+
+	for _, s := range settings {
+		if out, err := exec.Command("git", "config", s[0], s[1]).CombinedOutput(); err != nil {
+			return fmt.Errorf("%w; Failed to set git config \"%s\": %s", err, s[0], strings.TrimSpace(string(out)))
+		}
+	}
+
+	fmt.Println("Registered merge driver in git config: " + driver)
+
+	attributesFile := root + "/.gitattributes"
+
+	existing, err := ioutil.ReadFile(attributesFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	lines := strings.Split(string(existing), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimSpace(lines[i])
+	}
+	added := make([]string, 0)
+
+	for _, pattern := range mergeDriverPatterns {
+		line := pattern + " merge=" + mergeDriverName
+
+		if !SliceContains(lines, line) {
+			added = append(added, line)
+		}
+	}
+
+	if len(added) == 0 {
+		fmt.Println("Merge driver already set up in: " + attributesFile)
+		return nil
+	}
+
+	content := string(existing)
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+
+	content += strings.Join(added, "\n") + "\n"
+
+	if err := ioutil.WriteFile(attributesFile, []byte(content), 0644); err != nil {
+		return fmt.Errorf("%w; Failed to write: %s", err, attributesFile)
+	}
+
+	fmt.Println("Added merge driver to: " + attributesFile)
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"github.com/motns/configstore/client"
+	"gopkg.in/urfave/cli.v1"
+	"strings"
+	"time"
+)
+
+func cmdSetMeta(c *cli.Context) error {
+	cc, err := OpenConfigstore(c.String("db"), make([]string, 0), c.Bool("ignore-role"))
+	if err != nil {
+		return err
+	}
+
+	return setMetaFromFlags(c, cc, c.Args().Get(0))
+}
+
+func cmdPackageSetMeta(c *cli.Context) error {
+	env, err := ParseEnv(c.Args().Get(0), c.String("basedir"), true)
+	if err != nil {
+		return err
+	}
+
+	if !env.isMainEnv() {
+		return errors.New("metadata can only be set in the Configstore of a top-level environment")
+	}
+
+	cc, err := ConfigstoreForEnv(env, c.Bool("ignore-role"))
+	if err != nil {
+		return err
+	}
+
+	return setMetaFromFlags(c, cc, c.Args().Get(1))
+}
+
+// setMetaFromFlags updates the metadata of the given key; only the fields for which a flag was passed are changed
+func setMetaFromFlags(c *cli.Context, cc *client.ConfigstoreClient, key string) error {
+	if key == "" {
+		return errors.New("you have to specify a key to set metadata for")
+	}
+
+	metadata, err := cc.GetMetadata(key)
+	if err != nil {
+		return err
+	}
+
+	if c.IsSet("description") {
+		metadata.Description = c.String("description")
+	}
+
+	if c.IsSet("owner") {
+		metadata.Owner = c.String("owner")
+	}
+
+	if c.Bool("clear-tags") {
+		metadata.Tags = nil
+	}
+
+	metadata.Tags = append(metadata.Tags, c.StringSlice("tag")...)
+
+	for _, t := range c.StringSlice("remove-tag") {
+		tags := make([]string, 0)
+
+		for _, mt := range metadata.Tags {
+			if mt != t {
+				tags = append(tags, mt)
+			}
+		}
+
+		metadata.Tags = tags
+	}
+
+	return cc.SetMetadata(key, metadata)
+}
+
+// MetadataLines formats the type and metadata of a key for display, one field per line, leaving out empty fields
+func MetadataLines(e client.ConfigstoreDBValue) []string {
+	lines := make([]string, 0)
+	m := e.KeyMetadata
+
+	if e.Type != "" {
+		lines = append(lines, "type: "+e.Type)
+	}
+
+	if m.Description != "" {
+		lines = append(lines, "description: "+m.Description)
+	}
+
+	if m.Owner != "" {
+		lines = append(lines, "owner: "+m.Owner)
+	}
+
+	if len(m.Tags) > 0 {
+		lines = append(lines, "tags: "+strings.Join(m.Tags, ", "))
+	}
+
+	if m.CreatedAt != nil {
+		lines = append(lines, "created: "+m.CreatedAt.Format(time.RFC3339))
+	}
+
+	if m.UpdatedAt != nil {
+		lines = append(lines, "updated: "+m.UpdatedAt.Format(time.RFC3339))
+	}
+
+	return lines
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/motns/configstore/client"
+	"gopkg.in/urfave/cli.v1"
+)
+
+func cmdMigrate(c *cli.Context) error {
+	dbFile := c.String("db")
+
+	cc, err := OpenConfigstore(dbFile, make([]string, 0), c.Bool("ignore-role"))
+	if err != nil {
+		return err
+	}
+
+	return migrateConfigstore(cc, dbFile, c.Bool("dry-run"))
+}
+
+func cmdPackageMigrate(c *cli.Context) error {
+	basedir := c.String("basedir")
+	envStr := c.Args().Get(0)
+
+	var envs = make([]Env, 0)
+
+	if envStr != "" {
+		env, err := ParseEnv(envStr, basedir, true)
+		if err != nil {
+			return err
+		}
+
+		if env.isSubenv() {
+			return errors.New("migrate command not supported for sub-environment")
+		}
+
+		envs = append(envs, env)
+	} else {
+		dirs, err := ListDirs(basedir + "/env")
+		if err != nil {
+			return err
+		}
+
+		for _, d := range dirs {
+			envs = append(envs, Env{
+				basedir:     basedir,
+				envName:     d,
+				subenvNames: nil,
+			})
+		}
+	}
+
+	for _, env := range envs {
+		cc, err := OpenConfigstore(env.dbFile(), make([]string, 0), c.Bool("ignore-role"))
+		if err != nil {
+			return err
+		}
+
+		if err := migrateConfigstore(cc, "env "+env.envStr(), c.Bool("dry-run")); err != nil {
+			return fmt.Errorf("%w; Failed to migrate env: %s", err, env.envStr())
+		}
+	}
+
+	return nil
+}
+
+// migrateConfigstore writes the given Configstore upgraded to the latest version, or only lists the migrations which
+// would be applied if dryRun is set
+func migrateConfigstore(cc *client.ConfigstoreClient, name string, dryRun bool) error {
+	pending := cc.PendingMigrations()
+	outcome := "would be migrated"
+
+	if !dryRun && len(pending) > 0 {
+		var err error
+		if pending, err = cc.Migrate(); err != nil {
+			return err
+		}
+
+		outcome = "was migrated"
+	}
+
+	// The DB file may have been migrated by someone else in the meantime
+	if len(pending) == 0 {
+		fmt.Printf("Configstore for %s is already at the latest version\n", name)
+		return nil
+	}
+
+	fmt.Printf("Configstore for %s %s from version %d:\n", name, outcome, pending[0].Version-1)
+
+	for _, m := range pending {
+		fmt.Printf("  %d: %s\n", m.Version, m.Description)
+	}
+
+	return nil
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"fmt"
+	"github.com/motns/configstore/client"
 	"gopkg.in/urfave/cli.v1"
 	"strings"
 )
@@ -93,18 +94,38 @@ func copyEnv(srcEnv Env, destEnv Env, keyPattern string, skipDecryption bool, sk
 		fmt.Println("Copying keys from " + srcEnv.envStr() + " to " + destEnv.envStr())
 	}
 
+	// All keys are written in one go, so a failure half way doesn't leave a partial copy behind
+	batch := dest.Batch()
+
 	for k, v := range srcMap {
 		if keyPattern == "" || strings.Contains(k, keyPattern) {
 			if !skipExisting || !dest.Exists(k) {
-				err := dest.Set(k, []byte(v.Value), v.IsSecret, v.IsBinary)
+				// Without decryption, secrets are copied as a placeholder, which isn't valid for most types
+				if skipDecryption && v.IsSecret && client.ValidateValue(v.Type, v.Value) != nil {
+					return errors.New("cannot copy secret of type " + v.Type + " with --skip-decryption, since its value would be replaced by a placeholder: " + k)
+				}
+
+				var err error
+
+				// The declared type of the key is copied along with the value (binary values are never typed)
+				if v.IsBinary {
+					err = batch.Set(k, []byte(v.Value), v.IsSecret, true)
+				} else {
+					err = batch.SetTyped(k, []byte(v.Value), v.IsSecret, v.Type)
+				}
+
 				if err != nil {
 					return err
 				}
+
+				if err := batch.SetMetadata(k, v.KeyMetadata); err != nil {
+					return err
+				}
 			}
 		}
 	}
 
-	return nil
+	return batch.Commit()
 }
 
 func copySubenv(srcEnv Env, destEnv Env, keyPattern string, skipExisting bool) error {
@@ -6,6 +6,7 @@ import (
 	"github.com/motns/configstore/client"
 	"gopkg.in/urfave/cli.v1"
 	"os"
+	"strings"
 )
 
 func cmdPackageCreate(c *cli.Context) error {
@@ -17,6 +18,11 @@ func cmdPackageCreate(c *cli.Context) error {
 		return err
 	}
 
+	schema, err := LoadPackageSchema(basedir)
+	if err != nil {
+		return err
+	}
+
 	fmt.Println("Creating environment: " + envStr)
 
 	if env.isMainEnv() { // We're creating a main environment
@@ -34,18 +40,76 @@ func cmdPackageCreate(c *cli.Context) error {
 		role := c.String("role")
 		masterKey := c.String("master-key")
 		isInsecure := c.Bool("insecure")
+		usePassphrase := c.Bool("passphrase")
+		ageRecipients := c.StringSlice("age-recipient")
+
+		encryptionContext, err := ParseEncryptionContext(c.StringSlice("encryption-context"))
+		if err != nil {
+			cleanup(dir)
+			return err
+		}
+
+		if encryptionContext != nil && (isInsecure || usePassphrase || len(ageRecipients) > 0) {
+			cleanup(dir)
+			return errors.New("--encryption-context can only be used with --master-key")
+		}
+
+		if AWSSettingsFromFlags(c) != nil && (isInsecure || usePassphrase || len(ageRecipients) > 0) {
+			cleanup(dir)
+			return errors.New("AWS settings can only be used with --master-key")
+		}
+
+		if usePassphrase {
+			if isInsecure || masterKey != "" || len(ageRecipients) > 0 {
+				cleanup(dir)
+				return errors.New("--passphrase cannot be combined with --insecure, --master-key or --age-recipient")
+			}
+
+			passphrase, err := ReadNewPassphrase()
+			if err != nil {
+				cleanup(dir)
+				return err
+			}
+
+			if _, err := client.InitPassphraseConfigstore(dir, passphrase); err != nil {
+				cleanup(dir)
+				return err
+			}
+
+			return applySchemaDefaults(env, schema)
+		}
+
+		if len(ageRecipients) > 0 {
+			if isInsecure || masterKey != "" {
+				cleanup(dir)
+				return errors.New("--age-recipient cannot be combined with --insecure or --master-key")
+			}
+
+			if _, err := client.InitAgeConfigstore(dir, ageRecipients); err != nil {
+				cleanup(dir)
+				return err
+			}
+
+			return applySchemaDefaults(env, schema)
+		}
 
 		if !isInsecure && masterKey == "" {
 			cleanup(dir)
-			return errors.New("you have to specify --master-key if --insecure is not set")
+			return errors.New("you have to specify --master-key if --insecure, --passphrase or --age-recipient is not set")
+		}
+
+		if isInsecure {
+			_, err = client.InitConfigstore(dir, region, role, masterKey, true)
+		} else {
+			_, err = client.InitKMSConfigstore(dir, region, role, masterKey, encryptionContext, AWSSettingsFromFlags(c), ClientOptions()...)
 		}
 
-		_, err := client.InitConfigstore(dir, region, role, masterKey, isInsecure)
 		if err != nil {
 			cleanup(dir)
 			return err
 		}
 
+		return applySchemaDefaults(env, schema)
 	} else { // We're creating a sub-environment
 		err := CreateSubenvShared(env)
 		if err != nil {
@@ -61,3 +125,49 @@ func cleanup(dir string) {
 		fmt.Printf("WARN: Failed to clean up directory \"%s\" after initialisation error - you need to manually remove it\n", dir)
 	}
 }
+
+// applySchemaDefaults sets the default value of every key in the package schema (if there is one) which has one,
+// in the Configstore of a newly created environment
+func applySchemaDefaults(env Env, schema *client.Schema) error {
+	if schema == nil {
+		return nil
+	}
+
+	cc, err := OpenConfigstore(env.dbFile(), make([]string, 0), true)
+	if err != nil {
+		return err
+	}
+
+	batch := cc.Batch()
+	missing := make([]string, 0)
+
+	for _, k := range schema.SortedKeys() {
+		sk := schema.Keys[k]
+
+		if sk.Default == nil {
+			if sk.Required {
+				missing = append(missing, k)
+			}
+
+			continue
+		}
+
+		if err := batch.SetTyped(k, []byte(*sk.Default), false, sk.Type); err != nil {
+			return err
+		}
+	}
+
+	if batch.Len() > 0 {
+		fmt.Printf("Setting default values from schema for %d keys\n", batch.Len())
+	}
+
+	if err := batch.Commit(); err != nil {
+		return fmt.Errorf("%w; Failed to set default values from schema", err)
+	}
+
+	if len(missing) > 0 {
+		fmt.Println("The following required keys still need to be set: " + strings.Join(missing, ", "))
+	}
+
+	return nil
+}
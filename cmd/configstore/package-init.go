@@ -5,6 +5,7 @@ import (
 	"gopkg.in/urfave/cli.v1"
 	"io/ioutil"
 	"os"
+	"strings"
 )
 
 func cmdPackageInit(c *cli.Context) error {
@@ -32,5 +33,32 @@ func cmdPackageInit(c *cli.Context) error {
 		return err
 	}
 
-	return nil
+	return ignoreLockFiles(basedir + "/.gitignore")
+}
+
+// The pattern for the lock files left next to each Configstore DB (see client/lock.go)
+const lockFilePattern = ".configstore*.lock"
+
+// ignoreLockFiles adds the lock files of the Configstore DBs to the given .gitignore file, unless they're already in it
+func ignoreLockFiles(gitignoreFile string) error {
+	existing, err := ioutil.ReadFile(gitignoreFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	lines := strings.Split(string(existing), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimSpace(lines[i])
+	}
+
+	if SliceContains(lines, lockFilePattern) {
+		return nil
+	}
+
+	content := string(existing)
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+
+	return ioutil.WriteFile(gitignoreFile, []byte(content+lockFilePattern+"\n"), 0644)
 }
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/urfave/cli.v1"
+)
+
+func cmdPackageRotateDataKey(c *cli.Context) error {
+	basedir := c.String("basedir")
+	envStr := c.Args().Get(0)
+	ignoreRole := c.Bool("ignore-role")
+
+	var envs = make([]Env, 0)
+
+	if envStr != "" {
+		env, err := ParseEnv(envStr, basedir, true)
+		if err != nil {
+			return err
+		}
+
+		if env.isSubenv() {
+			return errors.New("rotate_data_key command not supported for sub-environment")
+		}
+
+		envs = append(envs, env)
+	} else {
+		dirs, err := ListDirs(basedir + "/env")
+		if err != nil {
+			return err
+		}
+
+		for _, d := range dirs {
+			envs = append(envs, Env{
+				basedir:     basedir,
+				envName:     d,
+				subenvNames: nil,
+			})
+		}
+	}
+
+	for _, env := range envs {
+		fmt.Println("Rotating Data Key for env: " + env.envStr())
+
+		cc, err := ConfigstoreForEnv(env, ignoreRole)
+		if err != nil {
+			return err
+		}
+
+		if err := cc.RotateDataKey(); err != nil {
+			return fmt.Errorf("%w; Failed to rotate Data Key for env: %s", err, env.envStr())
+		}
+	}
+
+	return nil
+}
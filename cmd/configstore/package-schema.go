@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/motns/configstore/client"
+	"gopkg.in/urfave/cli.v1"
+	"os"
+)
+
+func cmdPackageSchemaInit(c *cli.Context) error {
+	basedir := c.String("basedir")
+	path := basedir + "/" + schemaFile
+
+	if _, err := os.Stat(path); err == nil && !c.Bool("force") {
+		return errors.New("schema file already exists (use --force to overwrite it): " + path)
+	}
+
+	envs, err := ListDirs(basedir + "/env")
+	if err != nil {
+		return err
+	}
+
+	if len(envs) == 0 {
+		return errors.New("no environments in package")
+	}
+
+	dbs := make([]map[string]client.ConfigstoreDBValue, 0, len(envs))
+
+	for _, env := range envs {
+		cc, err := OpenConfigstore(client.FindDBFile(basedir+"/env/"+env), make([]string, 0), true)
+		if err != nil {
+			return err
+		}
+
+		entries, err := cc.GetAll(true)
+		if err != nil {
+			return err
+		}
+
+		dbs = append(dbs, entries)
+	}
+
+	schema := client.GenerateSchema(dbs)
+
+	if err := schema.Save(path); err != nil {
+		return fmt.Errorf("%w; Failed to write schema file: %s", err, path)
+	}
+
+	fmt.Printf("Schema for %d keys written to: %s\n", len(schema.Keys), path)
+	return nil
+}
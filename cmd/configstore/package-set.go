@@ -2,6 +2,8 @@ package main
 
 import (
 	"errors"
+	"fmt"
+	"github.com/motns/configstore/client"
 	"gopkg.in/urfave/cli.v1"
 )
 
@@ -18,10 +20,64 @@ func cmdPackageSet(c *cli.Context) error {
 		return errors.New("env doesn't exist: " + env.envStr())
 	}
 
+	schema, err := LoadPackageSchema(basedir)
+	if err != nil {
+		return err
+	}
+
 	isSecret := c.Bool("secret")
 	isBinary := c.Bool("binary")
 	key := c.Args().Get(1)
 	val := c.Args().Get(2)
+	path := c.String("from-file")
+	valueType := c.String("type")
+
+	if isBinary && valueType != "" {
+		return errors.New("cannot declare a type for binary values")
+	}
+
+	if valueType != "" && !env.isMainEnv() {
+		return errors.New("types can only be declared in the Configstore of a top-level environment")
+	}
+
+	if path != "" {
+		if key != "" {
+			return errors.New("cannot pass a key and value when setting values from a file")
+		}
+
+		if env.isMainEnv() {
+			cc, err := ConfigstoreForEnv(env, c.Bool("ignore-role"))
+			if err != nil {
+				return err
+			}
+
+			return setFromFile(cc, path, isSecret, isBinary, valueType, schema)
+		}
+
+		if isSecret == true {
+			return errors.New("secret values cannot be stored in overrides")
+		}
+
+		values, err := ReadValuesFile(path)
+		if err != nil {
+			return err
+		}
+
+		overrides, err := LoadEnvOverride(env.envPath())
+		if err != nil {
+			return err
+		}
+
+		for k, v := range values {
+			overrides[k] = v
+		}
+
+		if err := validateOverrides(env, values, schema); err != nil {
+			return err
+		}
+
+		return SaveEnvOverride(env.envPath(), overrides)
+	}
 
 	rawValue, err := ReadRawValue(isSecret, val)
 	if err != nil {
@@ -34,6 +90,15 @@ func cmdPackageSet(c *cli.Context) error {
 			return err
 		}
 
+		valueType, err := schemaType(schema, key, rawValue, isSecret, isBinary, valueType)
+		if err != nil {
+			return err
+		}
+
+		if valueType != "" {
+			return cc.SetTyped(key, rawValue, isSecret, valueType)
+		}
+
 		return cc.Set(key, rawValue, isSecret, isBinary)
 	} else { // We're updating a sub-environment
 		overrides, err := LoadEnvOverride(env.envPath())
@@ -47,6 +112,68 @@ func cmdPackageSet(c *cli.Context) error {
 
 		overrides[key] = string(rawValue)
 
+		if err := validateOverrides(env, map[string]string{key: string(rawValue)}, schema); err != nil {
+			return err
+		}
+
 		return SaveEnvOverride(env.envPath(), overrides)
 	}
 }
+
+// validateOverrides checks the given override values against the Configstore of the main environment, and the
+// package schema (if there is one)
+func validateOverrides(env Env, values map[string]string, schema *client.Schema) error {
+	cc, err := OpenConfigstore(env.dbFile(), make([]string, 0), true)
+	if err != nil {
+		return err
+	}
+
+	for k, v := range values {
+		if err := cc.ValidateOverride(k, v); err != nil {
+			return err
+		}
+
+		if schema != nil {
+			if err := schema.CheckOverride(k, v); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// schemaType checks a value being set in a main environment against the package schema (if there is one), and
+// returns the type to declare for it
+func schemaType(schema *client.Schema, key string, rawValue []byte, isSecret bool, isBinary bool, valueType string) (string, error) {
+	if schema == nil {
+		return valueType, nil
+	}
+
+	sk, err := schema.Key(key)
+	if err != nil {
+		return "", err
+	}
+
+	if sk.Secret && !isSecret {
+		return "", errors.New("schema requires key to be stored as a secret (use --secret): " + key)
+	}
+
+	if valueType != "" && sk.Type != "" && valueType != sk.Type {
+		return "", fmt.Errorf("schema declares key %s as type %s, not %s", key, sk.Type, valueType)
+	}
+
+	if isBinary {
+		if sk.Type != "" && sk.Type != client.TypeString {
+			return "", fmt.Errorf("schema declares key %s as type %s, which cannot be binary", key, sk.Type)
+		}
+
+		return "", nil
+	}
+
+	if sk.Type != "" {
+		valueType = sk.Type
+	}
+
+	return valueType, sk.ValidateValue(key, string(rawValue))
+}
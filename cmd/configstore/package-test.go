@@ -21,18 +21,31 @@ func cmdPackageTest(c *cli.Context) error {
 		return errors.New("no environments in package")
 	}
 
-	path1 := basedir + "/env/" + envs[0] + "/configstore.json"
-	cc1, err := client.NewConfigstoreClient(path1, make([]string, 0), true)
+	schema, err := LoadPackageSchema(basedir)
+	if err != nil {
+		return err
+	}
+
+	path1 := client.FindDBFile(basedir + "/env/" + envs[0])
+	cc1, err := OpenConfigstore(path1, make([]string, 0), true)
 
-	// Only run key comparison if we have more than one Configstores
-	if len(envs) != 1 {
+	// With a schema, keys are checked against that instead, since optional keys may be missing from some envs
+	if schema != nil {
+		if err != nil {
+			return err
+		}
+
+		if err := checkSchema(basedir, envs, schema); err != nil {
+			return err
+		}
+	} else if len(envs) != 1 { // Only run key comparison if we have more than one Configstores
 		if err != nil {
 			return err
 		}
 
 		for _, env := range envs[1:] {
-			path2 := basedir + "/env/" + env + "/configstore.json"
-			cc2, err := client.NewConfigstoreClient(path2, make([]string, 0), true)
+			path2 := client.FindDBFile(basedir + "/env/" + env)
+			cc2, err := OpenConfigstore(path2, make([]string, 0), true)
 
 			if err != nil {
 				return err
@@ -53,7 +66,7 @@ func cmdPackageTest(c *cli.Context) error {
 		fmt.Printf("Checking sub-environments for env: %s\n", env)
 
 		envBasePath := basedir + "/env/" + env
-		cc, err := client.NewConfigstoreClient(envBasePath+"/configstore.json", make([]string, 0), true)
+		cc, err := OpenConfigstore(client.FindDBFile(envBasePath), make([]string, 0), true)
 		if err != nil {
 			return err
 		}
@@ -66,13 +79,17 @@ func cmdPackageTest(c *cli.Context) error {
 		}
 
 		for _, d := range subdirs {
-			err := checkOverride(basedir, env+"/"+d, baseKeys)
+			err := checkOverride(basedir, env+"/"+d, baseKeys, cc, schema)
 			if err != nil {
 				return err
 			}
 		}
 	}
 
+	if err := checkTypes(basedir, envs); err != nil {
+		return err
+	}
+
 	templateFiles, err := ListFiles(basedir + "/template")
 
 	if err != nil {
@@ -99,7 +116,7 @@ func cmdPackageTest(c *cli.Context) error {
 	return nil
 }
 
-func checkOverride(basedir string, env string, baseKeys []string) error {
+func checkOverride(basedir string, env string, baseKeys []string, cc *client.ConfigstoreClient, schema *client.Schema) error {
 	fmt.Printf("Checking sub-environment: %s\n", env)
 	envPath := basedir + "/env/" + env
 
@@ -108,10 +125,20 @@ func checkOverride(basedir string, env string, baseKeys []string) error {
 		return err
 	}
 
-	for k := range override {
+	for k, v := range override {
 		if !SliceContains(baseKeys, k) {
 			return fmt.Errorf("key \"%s\" from override \"%s\" not in base Configstore DB", k, env)
 		}
+
+		if err := cc.ValidateOverride(k, v); err != nil {
+			return fmt.Errorf("%w; Failed to check override \"%s\"", err, env)
+		}
+
+		if schema != nil {
+			if err := schema.CheckOverride(k, v); err != nil {
+				return fmt.Errorf("%w; Failed to check override \"%s\" against schema", err, env)
+			}
+		}
 	}
 
 	subdirs, err := ListDirs(envPath)
@@ -120,7 +147,7 @@ func checkOverride(basedir string, env string, baseKeys []string) error {
 	}
 
 	for _, d := range subdirs {
-		err := checkOverride(basedir, env+"/"+d, baseKeys)
+		err := checkOverride(basedir, env+"/"+d, baseKeys, cc, schema)
 		if err != nil {
 			return err
 		}
@@ -128,3 +155,72 @@ func checkOverride(basedir string, env string, baseKeys []string) error {
 
 	return nil
 }
+
+// checkTypes makes sure that each key is declared with the same type in every environment, and that plain text
+// values are valid for their type. Secrets aren't checked, since that would require decrypting them.
+func checkTypes(basedir string, envs []string) error {
+	types := make(map[string]string)
+	typeEnvs := make(map[string]string)
+
+	for _, env := range envs {
+		fmt.Printf("Checking value types for env: %s\n", env)
+
+		cc, err := OpenConfigstore(client.FindDBFile(basedir+"/env/"+env), make([]string, 0), true)
+		if err != nil {
+			return err
+		}
+
+		entries, err := cc.GetAll(true)
+		if err != nil {
+			return err
+		}
+
+		for k, e := range entries {
+			if t, seen := types[k]; seen && t != e.Type {
+				return fmt.Errorf("key \"%s\" is %s in env \"%s\", but %s in env \"%s\"", k, describeType(e.Type), env, describeType(t), typeEnvs[k])
+			}
+
+			types[k] = e.Type
+			typeEnvs[k] = env
+
+			if !e.IsSecret {
+				if err := client.ValidateValue(e.Type, e.Value); err != nil {
+					return fmt.Errorf("%w; Invalid value for key \"%s\" in env \"%s\"", err, k, env)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkSchema checks the Configstore DB of every environment against the package schema
+func checkSchema(basedir string, envs []string, schema *client.Schema) error {
+	for _, env := range envs {
+		fmt.Printf("Checking schema for env: %s\n", env)
+
+		cc, err := OpenConfigstore(client.FindDBFile(basedir+"/env/"+env), make([]string, 0), true)
+		if err != nil {
+			return err
+		}
+
+		entries, err := cc.GetAll(true)
+		if err != nil {
+			return err
+		}
+
+		if err := schema.CheckDB(entries); err != nil {
+			return fmt.Errorf("%w; Schema check failed for env \"%s\"", err, env)
+		}
+	}
+
+	return nil
+}
+
+func describeType(t string) string {
+	if t == "" {
+		return "untyped"
+	}
+
+	return "of type \"" + t + "\""
+}
@@ -13,6 +13,7 @@ type Tree = map[string]TreeNode
 
 type TreeNode struct {
 	value    string
+	meta     []string
 	children Tree
 }
 
@@ -40,8 +41,12 @@ func cmdPackageTree(c *cli.Context) error {
 		return err
 	}
 
-	allKeys := getAllConfigstoreKeys(configstores, c.Args().Get(0))
-	configTree, err := buildTree(basedir, configstores, allKeys, skipDecryption)
+	allKeys, err := getAllConfigstoreKeysWithTags(configstores, c.Args().Get(0), c.StringSlice("tag"))
+	if err != nil {
+		return err
+	}
+
+	configTree, err := buildTree(basedir, configstores, allKeys, skipDecryption, c.Bool("meta"))
 	if err != nil {
 		return err
 	}
@@ -51,7 +56,7 @@ func cmdPackageTree(c *cli.Context) error {
 	return nil
 }
 
-func buildTree(basedir string, configstores map[string]*client.ConfigstoreClient, allKeys []string, skipDecryption bool) (Tree, error) {
+func buildTree(basedir string, configstores map[string]*client.ConfigstoreClient, allKeys []string, skipDecryption bool, showMeta bool) (Tree, error) {
 	configTree := make(Tree)
 	cache := createCache()
 
@@ -72,6 +77,7 @@ func buildTree(basedir string, configstores map[string]*client.ConfigstoreClient
 			}
 
 			var val string
+			var meta []string
 			entry, exists := entries[k]
 
 			if exists {
@@ -84,6 +90,10 @@ func buildTree(basedir string, configstores map[string]*client.ConfigstoreClient
 				if entry.IsSecret {
 					val = formatYellow(val)
 				}
+
+				if showMeta {
+					meta = MetadataLines(entry)
+				}
 			} else {
 				val = formatRed("(missing)")
 			}
@@ -100,6 +110,7 @@ func buildTree(basedir string, configstores map[string]*client.ConfigstoreClient
 
 			configTree[k].children[env] = TreeNode{
 				value:    val,
+				meta:     meta,
 				children: subtree,
 			}
 		}
@@ -147,6 +158,38 @@ func getAllConfigstoreKeys(configstores map[string]*client.ConfigstoreClient, ke
 	return allKeys
 }
 
+// getAllConfigstoreKeysWithTags works like getAllConfigstoreKeys, but only keeps the keys which have all of the
+// given tags in at least one of the Configstores
+func getAllConfigstoreKeysWithTags(configstores map[string]*client.ConfigstoreClient, keyFilter string, tags []string) ([]string, error) {
+	allKeys := getAllConfigstoreKeys(configstores, keyFilter)
+
+	if len(tags) == 0 {
+		return allKeys, nil
+	}
+
+	keys := make([]string, 0)
+
+	for _, k := range allKeys {
+		for _, cc := range configstores {
+			if !cc.Exists(k) {
+				continue
+			}
+
+			m, err := cc.GetMetadata(k)
+			if err != nil {
+				return nil, err
+			}
+
+			if m.HasTags(tags) {
+				keys = append(keys, k)
+				break
+			}
+		}
+	}
+
+	return keys, nil
+}
+
 func buildSubTree(key string, basedir string, cache SubenvCache) (Tree, bool, error) {
 	subenvs, err := ListDirs(basedir)
 	if err != nil {
@@ -257,6 +300,10 @@ func printTreeNode(key string, node TreeNode, indent int, isRoot bool) {
 
 	fmt.Println(out)
 
+	for _, l := range node.meta {
+		fmt.Println(strings.Repeat(" ", indent+2) + l)
+	}
+
 	if len(node.children) != 0 {
 		printTree(node.children, indent+2, false)
 	}
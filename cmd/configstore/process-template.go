@@ -3,13 +3,12 @@ package main
 import (
 	"errors"
 	"fmt"
-	"github.com/motns/configstore/client"
 	"gopkg.in/urfave/cli.v1"
 	"io/ioutil"
 )
 
 func cmdProcessTemplate(c *cli.Context) error {
-	cc, err := client.NewConfigstoreClient(c.String("db"), c.StringSlice("override"), c.Bool("ignore-role"))
+	cc, err := OpenConfigstore(c.String("db"), c.StringSlice("override"), c.Bool("ignore-role"))
 	if err != nil {
 		return err
 	}
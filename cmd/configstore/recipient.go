@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/urfave/cli.v1"
+)
+
+func cmdAddRecipient(c *cli.Context) error {
+	recipient := c.Args().Get(0)
+	if recipient == "" {
+		return errors.New("you have to specify an age public key as the first argument")
+	}
+
+	cc, err := OpenConfigstore(c.String("db"), make([]string, 0), false)
+	if err != nil {
+		return err
+	}
+
+	if err := cc.AddAgeRecipient(recipient); err != nil {
+		return err
+	}
+
+	fmt.Println("Added age recipient: " + recipient)
+	return nil
+}
+
+func cmdRemoveRecipient(c *cli.Context) error {
+	recipient := c.Args().Get(0)
+	if recipient == "" {
+		return errors.New("you have to specify an age public key as the first argument")
+	}
+
+	cc, err := OpenConfigstore(c.String("db"), make([]string, 0), false)
+	if err != nil {
+		return err
+	}
+
+	if err := cc.RemoveAgeRecipient(recipient); err != nil {
+		return err
+	}
+
+	fmt.Println("Removed age recipient: " + recipient)
+	return nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/urfave/cli.v1"
+)
+
+func cmdRewrap(c *cli.Context) error {
+	masterKey := c.String("master-key")
+	if masterKey == "" {
+		return errors.New("you have to specify the new Master Key via --master-key")
+	}
+
+	cc, err := OpenConfigstore(c.String("db"), make([]string, 0), c.Bool("ignore-role"))
+	if err != nil {
+		return err
+	}
+
+	if err := cc.RewrapDataKey(masterKey, c.String("region"), c.String("role")); err != nil {
+		return err
+	}
+
+	fmt.Println("Data Key re-wrapped with Master Key: " + masterKey)
+	return nil
+}
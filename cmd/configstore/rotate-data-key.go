@@ -0,0 +1,14 @@
+package main
+
+import (
+	"gopkg.in/urfave/cli.v1"
+)
+
+func cmdRotateDataKey(c *cli.Context) error {
+	cc, err := OpenConfigstore(c.String("db"), make([]string, 0), c.Bool("ignore-role"))
+	if err != nil {
+		return err
+	}
+
+	return cc.RotateDataKey()
+}
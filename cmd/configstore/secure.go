@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/urfave/cli.v1"
+)
+
+func cmdSecure(c *cli.Context) error {
+	masterKey := c.String("master-key")
+	if masterKey == "" {
+		return errors.New("you have to specify the Master Key to secure the Configstore with via --master-key")
+	}
+
+	cc, err := OpenConfigstore(c.String("db"), make([]string, 0), false)
+	if err != nil {
+		return err
+	}
+
+	if err := cc.Secure(c.String("region"), c.String("role"), masterKey); err != nil {
+		return err
+	}
+
+	fmt.Println("Configstore secured with Master Key: " + masterKey)
+	return nil
+}
+
+func cmdInsecure(c *cli.Context) error {
+	cc, err := OpenConfigstore(c.String("db"), make([]string, 0), c.Bool("ignore-role"))
+	if err != nil {
+		return err
+	}
+
+	if err := cc.MakeInsecure(); err != nil {
+		return err
+	}
+
+	fmt.Println("WARNING: The Data Key for this Configstore is now stored in plain text")
+	return nil
+}
@@ -1,12 +1,13 @@
 package main
 
 import (
+	"errors"
 	"github.com/motns/configstore/client"
 	"gopkg.in/urfave/cli.v1"
 )
 
 func cmdSet(c *cli.Context) error {
-	cc, err := client.NewConfigstoreClient(c.String("db"), make([]string, 0), c.Bool("ignore-role"))
+	cc, err := OpenConfigstore(c.String("db"), make([]string, 0), c.Bool("ignore-role"))
 	if err != nil {
 		return err
 	}
@@ -15,11 +16,58 @@ func cmdSet(c *cli.Context) error {
 	val := c.Args().Get(1)
 	isSecret := c.Bool("secret")
 	isBinary := c.Bool("binary")
+	valueType := c.String("type")
+
+	if isBinary && valueType != "" {
+		return errors.New("cannot declare a type for binary values")
+	}
+
+	if path := c.String("from-file"); path != "" {
+		if key != "" {
+			return errors.New("cannot pass a key and value when setting values from a file")
+		}
+
+		return setFromFile(cc, path, isSecret, isBinary, valueType, nil)
+	}
 
 	rawValue, err := ReadRawValue(isSecret, val)
 	if err != nil {
 		return err
 	}
 
+	if valueType != "" {
+		return cc.SetTyped(key, rawValue, isSecret, valueType)
+	}
+
 	return cc.Set(key, rawValue, isSecret, isBinary)
 }
+
+// setFromFile stores every key/value pair from the given values file, writing the DB only once. If valueType
+// is set, it's declared for every key. If a package schema is passed, every value is checked against it.
+func setFromFile(cc *client.ConfigstoreClient, path string, isSecret bool, isBinary bool, valueType string, schema *client.Schema) error {
+	values, err := ReadValuesFile(path)
+	if err != nil {
+		return err
+	}
+
+	batch := cc.Batch()
+
+	for k, v := range values {
+		t, err := schemaType(schema, k, []byte(v), isSecret, isBinary, valueType)
+		if err != nil {
+			return err
+		}
+
+		if t != "" {
+			err = batch.SetTyped(k, []byte(v), isSecret, t)
+		} else {
+			err = batch.Set(k, []byte(v), isSecret, isBinary)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return batch.Commit()
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/howeyc/gopass"
 	"github.com/motns/configstore/client"
+	"gopkg.in/urfave/cli.v1"
 	"io/ioutil"
 	"os"
 	"sort"
@@ -64,7 +65,7 @@ func (e *Env) isMainEnv() bool {
 }
 
 func (e *Env) dbFile() string {
-	return e.mainEnvPath() + "/configstore.json"
+	return client.FindDBFile(e.mainEnvPath())
 }
 
 func (e *Env) overrideFile() (string, error) {
@@ -141,13 +142,28 @@ func LoadEnvOverride(basedir string) (map[string]string, error) {
 		case *json.SyntaxError:
 			return nil, fmt.Errorf("%w; Failed to unmarshal json for subenv \"%s\", error at position %d (\"%s\")", err, basedir, t.Offset, client.SafeSlice(string(jsonStr), int(t.Offset - 10), int(t.Offset + 10)))
 		default:
-			return nil, fmt.Errorf("%w; Failed to unmarshal json for subenv \"%s\"", err)
+			return nil, fmt.Errorf("%w; Failed to unmarshal json for subenv \"%s\"", err, basedir)
 		}
 	}
 
 	return overrides, nil
 }
 
+// The schema file of a package, relative to its base directory (see client.Schema)
+const schemaFile = "schema.json"
+
+// LoadPackageSchema loads the schema of the package in the given base directory, returning nil if the package
+// doesn't have one
+func LoadPackageSchema(basedir string) (*client.Schema, error) {
+	path := basedir + "/" + schemaFile
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	return client.LoadSchema(path)
+}
+
 func SaveEnvOverride(basedir string, override map[string]string) error {
 	jsonStr, err := json.MarshalIndent(override, "", "  ")
 
@@ -158,8 +174,55 @@ func SaveEnvOverride(basedir string, override map[string]string) error {
 	return ioutil.WriteFile(basedir+"/override.json", jsonStr, 0644)
 }
 
+// Settings shared by all commands, set via the global flags
+var (
+	// Overrides for the AWS settings stored in Configstore DBs
+	awsOverrides client.AWSSettings
+	// Refuse to load Configstore DBs which fail integrity verification
+	requireIntegrity bool
+)
+
+// OpenConfigstore loads a Configstore DB with the client options shared by all commands which may
+// need to decrypt values
+func OpenConfigstore(dbFile string, overrideFiles []string, ignoreRole bool) (*client.ConfigstoreClient, error) {
+	opts := ClientOptions()
+
+	if requireIntegrity {
+		opts = append(opts, client.WithRequireIntegrity())
+	}
+
+	return client.NewConfigstoreClient(dbFile, overrideFiles, ignoreRole, opts...)
+}
+
+// ClientOptions returns the client options needed for decrypting values
+func ClientOptions() []client.ClientOption {
+	return []client.ClientOption{
+		client.WithPassphraseFunc(PromptPassphrase),
+		client.WithAWSOverrides(awsOverrides),
+		client.WithMFATokenFunc(PromptMFAToken),
+	}
+}
+
+// AWSSettingsFromFlags reads the AWS settings from the flags of the given command, returning
+// nil if none of them were set
+func AWSSettingsFromFlags(c *cli.Context) *client.AWSSettings {
+	settings := client.AWSSettings{
+		Endpoint:    c.String("kms-endpoint"),
+		Profile:     c.String("aws-profile"),
+		ExternalId:  c.String("external-id"),
+		SessionName: c.String("role-session-name"),
+		MFASerial:   c.String("mfa-serial"),
+	}
+
+	if settings == (client.AWSSettings{}) {
+		return nil
+	}
+
+	return &settings
+}
+
 func ConfigstoreForEnv(env Env, ignoreRole bool) (*client.ConfigstoreClient, error) {
-	cc, err := client.NewConfigstoreClient(env.dbFile(), env.overrideFiles(), ignoreRole)
+	cc, err := OpenConfigstore(env.dbFile(), env.overrideFiles(), ignoreRole)
 	if err != nil {
 		return nil, err
 	}
@@ -196,6 +259,101 @@ func ReadRawValue(isSecret bool, fallback string) ([]byte, error) {
 	return []byte(fallback), nil
 }
 
+// ReadValuesFile loads key/value pairs for setting multiple values at once from a JSON file with a single, flat
+// object in it (just like an override file). Passing "-" reads the JSON from StdIn instead.
+func ReadValuesFile(path string) (map[string]string, error) {
+	var jsonStr []byte
+	var err error
+
+	if path == "-" {
+		jsonStr, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		jsonStr, err = ioutil.ReadFile(path)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%w; Failed to read values file: %s", err, path)
+	}
+
+	var values = make(map[string]string)
+
+	if err := json.Unmarshal(jsonStr, &values); err != nil {
+		return nil, fmt.Errorf("%w; Failed to unmarshal json from values file \"%s\"", err, path)
+	}
+
+	return values, nil
+}
+
+// promptSilent reads a silent input from the terminal. The prompt goes to stderr, so that it doesn't end up in
+// the output of commands like get when that's captured.
+func promptSilent(prompt string) ([]byte, error) {
+	return gopass.GetPasswdPrompt(prompt, false, os.Stdin, os.Stderr)
+}
+
+// PromptPassphrase asks for the passphrase of a passphrase-protected Configstore via a silent input
+func PromptPassphrase() ([]byte, error) {
+	return promptSilent("Passphrase:")
+}
+
+// PromptMFAToken asks for the MFA token code needed to assume an IAM Role via a silent input
+func PromptMFAToken() (string, error) {
+	token, err := promptSilent("MFA token code:")
+	if err != nil {
+		return "", err
+	}
+
+	return string(token), nil
+}
+
+// ReadNewPassphrase gets the passphrase for a new Configstore, either from the environment (see
+// client.PassphraseFromEnv), or by prompting for it twice to avoid typos
+func ReadNewPassphrase() ([]byte, error) {
+	passphrase, err := client.PassphraseFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	if passphrase != nil {
+		return passphrase, nil
+	}
+
+	passphrase, err = PromptPassphrase()
+	if err != nil {
+		return nil, err
+	}
+
+	confirmed, err := promptSilent("Confirm passphrase:")
+	if err != nil {
+		return nil, err
+	}
+
+	if string(passphrase) != string(confirmed) {
+		return nil, errors.New("passphrases did not match")
+	}
+
+	return passphrase, nil
+}
+
+// ParseEncryptionContext turns a list of "key=value" pairs into a KMS encryption context
+func ParseEncryptionContext(pairs []string) (map[string]string, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+
+	context := make(map[string]string, len(pairs))
+
+	for _, pair := range pairs {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.New("encryption context has to be specified as key=value, got: " + pair)
+		}
+
+		context[parts[0]] = parts[1]
+	}
+
+	return context, nil
+}
+
 func CreateSubenvShared(env Env) error {
 	if !env.mainEnvExists() {
 		return errors.New("main environment doesn't exist: " + env.envName)
@@ -3,7 +3,6 @@ package main
 import (
 	"errors"
 	"fmt"
-	"github.com/motns/configstore/client"
 	"gopkg.in/urfave/cli.v1"
 	"io/ioutil"
 )
@@ -11,7 +10,7 @@ import (
 func cmdTestTemplate(c *cli.Context) error {
 	dbFile := c.String("db")
 
-	cc, err := client.NewConfigstoreClient(dbFile, make([]string, 0), c.Bool("ignore-role"))
+	cc, err := OpenConfigstore(dbFile, make([]string, 0), c.Bool("ignore-role"))
 	if err != nil {
 		return err
 	}
@@ -2,14 +2,13 @@ package main
 
 import (
 	"errors"
-	"github.com/motns/configstore/client"
 	"gopkg.in/urfave/cli.v1"
 )
 
 func cmdUnset(c *cli.Context) error {
 	dbFile := c.String("db")
 
-	cc, err := client.NewConfigstoreClient(dbFile, make([]string, 0), c.Bool("ignore-role"))
+	cc, err := OpenConfigstore(dbFile, make([]string, 0), c.Bool("ignore-role"))
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"gopkg.in/urfave/cli.v1"
+)
+
+func cmdVerify(c *cli.Context) error {
+	cc, err := OpenConfigstore(c.String("db"), make([]string, 0), c.Bool("ignore-role"))
+	if err != nil {
+		return err
+	}
+
+	if err := cc.Verify(); err != nil {
+		return err
+	}
+
+	fmt.Println("Configstore integrity verified")
+	return nil
+}
+
+func cmdEnableIntegrity(c *cli.Context) error {
+	cc, err := OpenConfigstore(c.String("db"), make([]string, 0), c.Bool("ignore-role"))
+	if err != nil {
+		return err
+	}
+
+	return cc.EnableIntegrity()
+}
+
+func cmdDisableIntegrity(c *cli.Context) error {
+	cc, err := OpenConfigstore(c.String("db"), make([]string, 0), c.Bool("ignore-role"))
+	if err != nil {
+		return err
+	}
+
+	return cc.DisableIntegrity()
+}
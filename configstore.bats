@@ -76,6 +76,94 @@
   rm -f test_data/configstore.json
 }
 
+@test "configstore set from file" {
+  rm -f test_data/configstore.json
+  bin/darwin/amd64/configstore init --dir test_data --insecure
+
+  echo '{"username": "root", "url": "https://example.com"}' > test_data/values.json
+
+  run bin/darwin/amd64/configstore set --db test_data/configstore.json --from-file test_data/values.json
+  [ "$status" -eq 0 ]
+
+  run bin/darwin/amd64/configstore get --db test_data/configstore.json url
+  [ "$status" -eq 0 ]
+  [ "$output" = "https://example.com" ]
+
+  run bin/darwin/amd64/configstore set --db test_data/configstore.json --from-file test_data/values.json username admin
+  [ "$status" -eq 1 ]
+
+  rm -f test_data/configstore.json test_data/values.json test_data/.configstore.json.lock
+}
+
+@test "configstore history and rollback" {
+  rm -f test_data/configstore.json
+  bin/darwin/amd64/configstore init --dir test_data --insecure
+  bin/darwin/amd64/configstore set --db test_data/configstore.json username root
+  bin/darwin/amd64/configstore set --db test_data/configstore.json username admin
+
+  run bin/darwin/amd64/configstore history --db test_data/configstore.json username
+  [ "$status" -eq 0 ]
+  [ "${lines[0]}" = "1: root" ]
+
+  run bin/darwin/amd64/configstore rollback --db test_data/configstore.json username
+  [ "$status" -eq 0 ]
+
+  run bin/darwin/amd64/configstore get --db test_data/configstore.json username
+  [ "$status" -eq 0 ]
+  [ "$output" = "root" ]
+
+  run bin/darwin/amd64/configstore rollback --db test_data/configstore.json username 5
+  [ "$status" -eq 1 ]
+
+  rm -f test_data/configstore.json test_data/.configstore.json.lock
+}
+
+@test "configstore set_meta" {
+  rm -f test_data/configstore.json
+  bin/darwin/amd64/configstore init --dir test_data --insecure
+  bin/darwin/amd64/configstore set --db test_data/configstore.json username root
+  bin/darwin/amd64/configstore set --db test_data/configstore.json url https://example.com
+
+  run bin/darwin/amd64/configstore set_meta --db test_data/configstore.json --owner ops --tag web url
+  [ "$status" -eq 0 ]
+
+  run bin/darwin/amd64/configstore ls --db test_data/configstore.json --tag web
+  [ "$status" -eq 0 ]
+  [ "$output" = "url: https://example.com" ]
+
+  run bin/darwin/amd64/configstore ls --db test_data/configstore.json --meta --tag web
+  [ "$status" -eq 0 ]
+  [ "${lines[1]}" = "  owner: ops" ]
+  [ "${lines[2]}" = "  tags: web" ]
+
+  run bin/darwin/amd64/configstore set_meta --db test_data/configstore.json --owner ops missing
+  [ "$status" -eq 1 ]
+
+  rm -f test_data/configstore.json test_data/.configstore.json.lock
+}
+
+@test "configstore set with type" {
+  rm -f test_data/configstore.json
+  bin/darwin/amd64/configstore init --dir test_data --insecure
+
+  run bin/darwin/amd64/configstore set --db test_data/configstore.json --type int port 80a
+  [ "$status" -eq 1 ]
+
+  run bin/darwin/amd64/configstore set --db test_data/configstore.json --type int port 8080
+  [ "$status" -eq 0 ]
+
+  run bin/darwin/amd64/configstore set --db test_data/configstore.json port eighty
+  [ "$status" -eq 1 ]
+
+  run bin/darwin/amd64/configstore set --db test_data/configstore.json --type string port eighty
+  [ "$status" -eq 0 ]
+
+  run bin/darwin/amd64/configstore set --db test_data/configstore.json --type number port 80
+  [ "$status" -eq 1 ]
+
+  rm -f test_data/configstore.json
+}
+
 @test "configstore encrypt and decrypt" {
   rm -f test_data/configstore.json
   bin/darwin/amd64/configstore init --dir test_data --insecure
@@ -99,6 +187,200 @@
   rm -f test_data/configstore.json
 }
 
+@test "configstore rotate_data_key" {
+  rm -f test_data/configstore.json
+  bin/darwin/amd64/configstore init --dir test_data --insecure
+  bin/darwin/amd64/configstore set --db test_data/configstore.json mykey myvalue
+  bin/darwin/amd64/configstore encrypt --db test_data/configstore.json mykey
+
+  run bin/darwin/amd64/configstore rotate_data_key --db test_data/configstore.json
+  [ "$status" -eq 0 ]
+
+  run bin/darwin/amd64/configstore get --db test_data/configstore.json mykey
+  [ "$status" -eq 0 ]
+  [ "$output" = "myvalue" ]
+
+  rm -f test_data/configstore.json
+}
+
+@test "configstore secure and insecure" {
+  rm -f test_data/configstore.json
+  bin/darwin/amd64/configstore init --dir test_data --insecure
+
+  run bin/darwin/amd64/configstore insecure --db test_data/configstore.json
+  [ "$status" -eq 1 ]
+
+  run bin/darwin/amd64/configstore secure --db test_data/configstore.json
+  [ "$status" -eq 1 ]
+
+  rm -f test_data/configstore.json
+}
+
+@test "configstore concurrent set" {
+  rm -f test_data/configstore.json
+  bin/darwin/amd64/configstore init --dir test_data --insecure
+
+  for i in 1 2 3 4 5; do
+    bin/darwin/amd64/configstore set --db test_data/configstore.json key$i value$i &
+  done
+  wait
+
+  run bin/darwin/amd64/configstore ls --db test_data/configstore.json
+  [ "$status" -eq 0 ]
+  [ "${#lines[@]}" -eq 5 ]
+
+  rm -f test_data/configstore.json test_data/.configstore.json.lock
+}
+
+@test "configstore generate" {
+  rm -f test_data/configstore.json
+  bin/darwin/amd64/configstore init --dir test_data --insecure
+
+  run bin/darwin/amd64/configstore generate --db test_data/configstore.json --length 12 --charset numeric pin password
+  [ "$status" -eq 0 ]
+
+  run bin/darwin/amd64/configstore get --db test_data/configstore.json pin
+  [ "$status" -eq 0 ]
+  [[ "$output" =~ ^[0-9]{12}$ ]]
+
+  run bin/darwin/amd64/configstore generate --db test_data/configstore.json pin uuid
+  [ "$status" -eq 1 ]
+
+  run bin/darwin/amd64/configstore generate --db test_data/configstore.json --format openssh deploy_key ed25519
+  [ "$status" -eq 0 ]
+
+  run bin/darwin/amd64/configstore get --db test_data/configstore.json deploy_key_public
+  [ "$status" -eq 0 ]
+  [[ "$output" =~ ^ssh-ed25519 ]]
+
+  rm -f test_data/configstore.json
+}
+
+@test "configstore merge_driver" {
+  rm -f test_data/base.json test_data/ours.json test_data/theirs.json
+  bin/darwin/amd64/configstore init --dir test_data --insecure
+  bin/darwin/amd64/configstore set --db test_data/configstore.json shared value
+  cp test_data/configstore.json test_data/base.json
+  cp test_data/configstore.json test_data/ours.json
+  mv test_data/configstore.json test_data/theirs.json
+
+  bin/darwin/amd64/configstore set --db test_data/ours.json ours 1
+  bin/darwin/amd64/configstore set --db test_data/theirs.json theirs 2
+
+  run bin/darwin/amd64/configstore merge_driver test_data/base.json test_data/ours.json test_data/theirs.json
+  [ "$status" -eq 0 ]
+
+  run bin/darwin/amd64/configstore ls --db test_data/ours.json
+  [ "${#lines[@]}" -eq 3 ]
+
+  bin/darwin/amd64/configstore set --db test_data/ours.json shared one
+  bin/darwin/amd64/configstore set --db test_data/theirs.json shared two
+
+  run bin/darwin/amd64/configstore merge_driver test_data/base.json test_data/ours.json test_data/theirs.json
+  [ "$status" -eq 1 ]
+
+  rm -f test_data/base.json test_data/ours.json test_data/theirs.json
+}
+
+@test "configstore migrate" {
+  rm -f test_data/configstore.json
+  cp test_data/example_configstore_v4.json test_data/configstore.json
+
+  run bin/darwin/amd64/configstore get --db test_data/configstore.json password
+  [ "$status" -eq 0 ]
+  [ "$output" = "supersecret" ]
+  cmp test_data/configstore.json test_data/example_configstore_v4.json
+
+  run bin/darwin/amd64/configstore set --db test_data/configstore.json mykey myvalue
+  [ "$status" -eq 1 ]
+
+  run bin/darwin/amd64/configstore migrate --dry-run --db test_data/configstore.json
+  [ "$status" -eq 0 ]
+  cmp test_data/configstore.json test_data/example_configstore_v4.json
+
+  run bin/darwin/amd64/configstore migrate --db test_data/configstore.json
+  [ "$status" -eq 0 ]
+
+  run bin/darwin/amd64/configstore set --db test_data/configstore.json mykey myvalue
+  [ "$status" -eq 0 ]
+
+  rm -f test_data/configstore.json
+}
+
+@test "configstore convert" {
+  rm -f test_data/configstore.json test_data/configstore.yaml test_data/configstore.toml
+  cp test_data/example_configstore.json test_data/configstore.json
+
+  run bin/darwin/amd64/configstore convert --db test_data/configstore.json --format xml
+  [ "$status" -eq 1 ]
+
+  run bin/darwin/amd64/configstore convert --db test_data/configstore.json --format yaml
+  [ "$status" -eq 0 ]
+  [ ! -f test_data/configstore.json ]
+
+  run bin/darwin/amd64/configstore get --db test_data/configstore.yaml password
+  [ "$status" -eq 0 ]
+  [ "$output" = "supersecret" ]
+
+  run bin/darwin/amd64/configstore convert --db test_data/configstore.yaml --format toml --keep
+  [ "$status" -eq 0 ]
+  [ -f test_data/configstore.yaml ]
+
+  run bin/darwin/amd64/configstore get --db test_data/configstore.toml password
+  [ "$status" -eq 0 ]
+  [ "$output" = "supersecret" ]
+
+  rm -f test_data/configstore.json test_data/configstore.yaml test_data/configstore.toml
+}
+
+@test "configstore remote storage" {
+  run bin/darwin/amd64/configstore get --db ftp://my-bucket/configstore.json password
+  [ "$status" -eq 1 ]
+  [ "$output" = "unknown storage backend: ftp" ]
+
+  run bin/darwin/amd64/configstore convert --db "s3://my-bucket/configstore.json?region=eu-west-1" --format yaml
+  [ "$status" -eq 1 ]
+  [ "$output" = "Configstore DBs in remote storage can only be copied, via --to" ]
+}
+
+@test "configstore init with encryption context" {
+  rm -f test_data/configstore.json
+
+  run bin/darwin/amd64/configstore init --dir test_data --insecure --encryption-context env=dev
+  [ "$status" -eq 1 ]
+
+  run bin/darwin/amd64/configstore init --dir test_data --master-key alias/my-key --encryption-context env
+  [ "$status" -eq 1 ]
+  [ "$output" = "encryption context has to be specified as key=value, got: env" ]
+}
+
+@test "configstore agent_lock without agent" {
+  CONFIGSTORE_AGENT_SOCK= run bin/darwin/amd64/configstore agent_lock
+  [ "$status" -eq 1 ]
+  [ "$output" = "CONFIGSTORE_AGENT_SOCK is not set; is the agent running?" ]
+}
+
+@test "configstore verify" {
+  rm -f test_data/configstore.json
+  bin/darwin/amd64/configstore init --dir test_data --insecure
+  bin/darwin/amd64/configstore set --db test_data/configstore.json mykey myvalue
+
+  run bin/darwin/amd64/configstore verify --db test_data/configstore.json
+  [ "$status" -eq 1 ]
+
+  run bin/darwin/amd64/configstore enable_integrity --db test_data/configstore.json
+  [ "$status" -eq 0 ]
+
+  run bin/darwin/amd64/configstore verify --db test_data/configstore.json
+  [ "$status" -eq 0 ]
+
+  sed -i.bak 's/myvalue/othervalue/' test_data/configstore.json
+  run bin/darwin/amd64/configstore verify --db test_data/configstore.json
+  [ "$status" -eq 1 ]
+
+  rm -f test_data/configstore.json test_data/configstore.json.bak
+}
+
 @test "configstore test_template" {
   run bin/darwin/amd64/configstore test_template --db test_data/example_configstore.json test_data/valid_template.txt
   [ "$status" -eq 0 ]
@@ -117,6 +399,7 @@
   [ -d "test_data/package_test" ]
   [ -d "test_data/package_test/env" ]
   [ -d "test_data/package_test/template" ]
+  grep -qx ".configstore\*.lock" test_data/package_test/.gitignore
 
   cp test_data/valid_template.txt test_data/package_test/template/valid_template.txt
 
@@ -263,6 +546,98 @@
   rm -rf test_data/out_test
 }
 
+@test "configstore package schema" {
+  rm -rf test_data/package_test
+
+  run bin/darwin/amd64/configstore package init test_data/package_test
+  run bin/darwin/amd64/configstore package create_env --insecure --basedir test_data/package_test dev
+  run bin/darwin/amd64/configstore package set --basedir test_data/package_test --type int dev port 8080
+  run bin/darwin/amd64/configstore package set --basedir test_data/package_test dev level info
+
+  run bin/darwin/amd64/configstore package schema init --basedir test_data/package_test
+  [ "$status" -eq 0 ]
+
+  run bin/darwin/amd64/configstore package schema init --basedir test_data/package_test
+  [ "$status" -eq 1 ]
+
+  echo '{"keys": {"port": {"type": "int", "required": true}, "level": {"allowed": ["debug", "info"], "default": "info"}}}' > test_data/package_test/schema.json
+
+  run bin/darwin/amd64/configstore package set --basedir test_data/package_test dev level trace
+  [ "$status" -eq 1 ]
+
+  run bin/darwin/amd64/configstore package set --basedir test_data/package_test dev other value
+  [ "$status" -eq 1 ]
+
+  run bin/darwin/amd64/configstore package create_env --insecure --basedir test_data/package_test live
+  [ "$status" -eq 0 ]
+
+  run bin/darwin/amd64/configstore package get --basedir test_data/package_test live level
+  [ "$output" = "info" ]
+
+  run bin/darwin/amd64/configstore package test --basedir test_data/package_test
+  [ "$status" -eq 1 ]
+
+  run bin/darwin/amd64/configstore package set --basedir test_data/package_test live port 8081
+  run bin/darwin/amd64/configstore package test --basedir test_data/package_test
+  [ "$status" -eq 0 ]
+
+  rm -rf test_data/package_test
+}
+
+@test "configstore package copy with types" {
+  rm -rf test_data/package_test
+
+  run bin/darwin/amd64/configstore package init test_data/package_test
+  run bin/darwin/amd64/configstore package create_env --insecure --basedir test_data/package_test dev
+  run bin/darwin/amd64/configstore package create_env --insecure --basedir test_data/package_test staging
+
+  run bin/darwin/amd64/configstore package set --basedir test_data/package_test --type int dev port 8080
+  [ "$status" -eq 0 ]
+
+  run bin/darwin/amd64/configstore package copy --basedir test_data/package_test dev staging
+  [ "$status" -eq 0 ]
+
+  run bin/darwin/amd64/configstore package get --basedir test_data/package_test staging port
+  [ "$status" -eq 0 ]
+  [ "$output" = "8080" ]
+
+  run bin/darwin/amd64/configstore package set --basedir test_data/package_test staging port eighty
+  [ "$status" -eq 1 ]
+
+  rm -rf test_data/package_test
+}
+
+@test "configstore package copy typed secret with skip decryption" {
+  rm -rf test_data/package_test
+
+  run bin/darwin/amd64/configstore package init test_data/package_test
+  run bin/darwin/amd64/configstore package create_env --insecure --basedir test_data/package_test dev
+  run bin/darwin/amd64/configstore package create_env --insecure --basedir test_data/package_test staging
+
+  run bash -c "echo -n 1234 | bin/darwin/amd64/configstore package set --basedir test_data/package_test --type int --secret dev pin"
+  [ "$status" -eq 0 ]
+
+  run bin/darwin/amd64/configstore package set --basedir test_data/package_test dev username root
+  [ "$status" -eq 0 ]
+
+  run bin/darwin/amd64/configstore package copy --basedir test_data/package_test --skip-decryption dev staging
+  [ "$status" -eq 1 ]
+  [[ "$output" == *"cannot copy secret of type int with --skip-decryption"* ]]
+
+  # Nothing is copied if the copy is refused
+  run bin/darwin/amd64/configstore package get --basedir test_data/package_test staging username
+  [ "$status" -eq 1 ]
+
+  run bin/darwin/amd64/configstore package copy --basedir test_data/package_test dev staging
+  [ "$status" -eq 0 ]
+
+  run bin/darwin/amd64/configstore package get --basedir test_data/package_test staging pin
+  [ "$status" -eq 0 ]
+  [ "$output" = "1234" ]
+
+  rm -rf test_data/package_test
+}
+
 @test "configstore package copy" {
   rm -rf test_data/package_test
   rm -rf test_data/out_test